@@ -0,0 +1,535 @@
+// Copyright 2012-2014 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//
+// Responding to requests: an SNMP agent serving registered MIB subtrees.
+//
+
+// MIBHandler serves the variables of a MIB subtree registered with an Agent.
+// The Agent calls a handler only for OIDs at or below its subtree, and
+// never concurrently.
+type MIBHandler interface {
+	// Get returns the variable named oid. If oid isn't an instance the
+	// handler serves, it returns a NoSuchInstance (or NoSuchObject) PDU.
+	Get(oid string) (SnmpPDU, error)
+
+	// GetNext returns the first variable of the subtree that follows oid
+	// in lexicographic order, or an EndOfMibView PDU when there is none.
+	// oid may be before the subtree, when the first variable is wanted.
+	GetNext(oid string) (SnmpPDU, error)
+
+	// Test checks that Set would succeed for pdu, without setting it, and
+	// returns the error Set would.
+	Test(pdu SnmpPDU) error
+
+	// Set sets the variable named pdu.Name to pdu.Value. It's only called
+	// once every varbind of the request has passed Test, and is called
+	// again with the value Get returned to undo a Set that has to be
+	// rolled back.
+	Set(pdu SnmpPDU) error
+}
+
+// HandlerError is returned by a MIBHandler to give the error-status of the
+// response, eg HandlerError{NotWritable}. Other errors are sent as GenErr.
+type HandlerError struct {
+	Status ErrorStatus // Status is the error-status, eg WrongType
+}
+
+func (e HandlerError) Error() string {
+	return fmt.Sprintf("SNMP error-status %s", e.Status)
+}
+
+// Agent answers SNMP Get, GetNext, GetBulk and Set requests, dispatching each
+// varbind to the MIBHandler registered for its subtree.
+//
+// Params supplies the Logger, the Community accepted from SNMPv1/v2c
+// requests and, for SNMPv3, the MsgFlags and SecurityParameters of the
+// agent's user. For SNMPv3 the agent is the authoritative engine, so
+// SecurityParameters.AuthoritativeEngineID (and boots) identify the agent;
+// its engine time counts from Listen. Authenticated requests outside the
+// engine's time window are answered with a usmStatsNotInTimeWindows report.
+// Params defaults to Default.
+type Agent struct {
+	Params *GoSNMP
+
+	udpListener
+	handlersMu       sync.RWMutex
+	handlers         []mibRegistration // sorted by subtree
+	unknownEngineIDs uint32
+	notInTimeWindows uint32
+}
+
+// mibRegistration is a MIBHandler and the subtree it serves
+type mibRegistration struct {
+	subtree OID
+	handler MIBHandler
+}
+
+// NewAgent returns an Agent, ready for Register and Listen
+func NewAgent() *Agent {
+	return &Agent{}
+}
+
+// Register adds handler for the MIB subtree, eg ".1.3.6.1.4.1.99999". The
+// subtrees of handlers can't overlap.
+func (a *Agent) Register(subtree string, handler MIBHandler) error {
+	oid, err := ParseOID(strings.TrimSuffix(subtree, "."))
+	if err != nil {
+		return err
+	}
+	a.handlersMu.Lock()
+	defer a.handlersMu.Unlock()
+	for _, r := range a.handlers {
+		if oid.HasPrefix(r.subtree) || r.subtree.HasPrefix(oid) {
+			return fmt.Errorf("Subtree %s overlaps registered subtree %s", oid, r.subtree)
+		}
+	}
+	a.handlers = append(a.handlers, mibRegistration{oid, handler})
+	sort.Slice(a.handlers, func(i, j int) bool {
+		return a.handlers[i].subtree.Less(a.handlers[j].subtree)
+	})
+	return nil
+}
+
+// Listen binds addr, eg "0.0.0.0:161", and answers requests until Close is
+// called or the socket fails. It returns nil after Close.
+func (a *Agent) Listen(addr string) error {
+	if a.Params == nil {
+		a.Params = Default
+	}
+	a.Params.secMu.Lock()
+	if sp := a.Params.session(); sp != nil {
+		// the engine time counts from now
+		sp.setEngineTime(sp.AuthoritativeEngineBoots, sp.AuthoritativeEngineTime)
+	}
+	a.Params.secMu.Unlock()
+	return a.serve(addr, a.Params, a.handle)
+}
+
+// handle answers one request
+func (a *Agent) handle(msg []byte, remote *net.UDPAddr) {
+	requestType, response, pdus, err := a.respond(msg)
+	if err != nil {
+		a.Params.logPrintf("Agent: discarding request from %s: %v", remote, err)
+		return
+	}
+	out, err := response.marshalMsg(pdus, response.PDUType, response.RequestID)
+	if err == nil && len(out) > maxResponseSize {
+		out, err = tooBig(requestType, response, pdus)
+	}
+	if err == nil {
+		err = a.reply(out, remote)
+	}
+	if err != nil {
+		a.Params.logPrintf("Agent: unable to respond to %s: %v", remote, err)
+	}
+}
+
+// maxResponseSize is the largest response that fits a UDP datagram
+const maxResponseSize = 65507
+
+// estimatedSize estimates the encoded size of the varbinds of a response,
+// from the length of each name and value
+func estimatedSize(pdus []SnmpPDU) int {
+	size := 0
+	for _, pdu := range pdus {
+		size += 8 + len(pdu.Name)
+		switch value := pdu.Value.(type) {
+		case string:
+			size += len(value)
+		case []byte:
+			size += len(value)
+		case OctetStringValue:
+			size += len(value)
+		default:
+			size += 8
+		}
+	}
+	return size
+}
+
+// tooBig shrinks a response that won't fit a datagram: GetBulk responses
+// are truncated, others replaced by a tooBig error - RFC 3416 4.2.1
+func tooBig(requestType PDUType, response *SnmpPacket, pdus []SnmpPDU) ([]byte, error) {
+	if requestType == GetBulkRequest {
+		for len(pdus) > 1 {
+			pdus = pdus[:len(pdus)/2]
+			out, err := response.marshalMsg(pdus, response.PDUType, response.RequestID)
+			if err != nil || len(out) <= maxResponseSize {
+				return out, err
+			}
+		}
+	}
+	response.Error = TooBig
+	response.ErrorIndex = 0
+	return response.marshalMsg([]SnmpPDU{}, response.PDUType, response.RequestID)
+}
+
+// respond decodes a request and builds the response
+func (a *Agent) respond(msg []byte) (PDUType, *SnmpPacket, []SnmpPDU, error) {
+	header := new(SnmpPacket)
+	if _, err := a.Params.unmarshalHeader(msg, header); err != nil {
+		return 0, nil, nil, err
+	}
+	if header.Version == Version3 {
+		sp := a.Params.SecurityParameters
+		if sp == nil {
+			return 0, nil, nil, fmt.Errorf("SNMPv3 is not configured")
+		}
+		if header.SecurityParameters.AuthoritativeEngineID != sp.AuthoritativeEngineID {
+			// engine discovery, or a request for another engine
+			a.unknownEngineIDs++
+			response, pdus, err := a.report(header, usmStatsUnknownEngineIDs, a.unknownEngineIDs)
+			return header.PDUType, response, pdus, err
+		}
+	}
+
+	a.Params.secMu.Lock()
+	request, err := a.Params.unmarshalResponse(msg)
+	a.Params.secMu.Unlock()
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	switch request.Version {
+	case Version1, Version2c:
+		if request.Community != a.Params.Community {
+			return 0, nil, nil, fmt.Errorf("unknown community %q", request.Community)
+		}
+	case Version3:
+		if request.SecurityParameters.UserName != a.Params.SecurityParameters.UserName {
+			return 0, nil, nil, fmt.Errorf("unknown user %q", request.SecurityParameters.UserName)
+		}
+		if request.MsgFlags&AuthNoPriv != 0 && !a.inTimeWindow(request.SecurityParameters) {
+			a.notInTimeWindows++
+			response, pdus, err := a.report(request, usmStatsNotInTimeWindows, a.notInTimeWindows)
+			return request.PDUType, response, pdus, err
+		}
+	}
+
+	response := a.mkResponse(request)
+	var pdus []SnmpPDU
+	switch request.PDUType {
+	case GetRequest:
+		pdus, err = a.get(request.Variables)
+	case GetNextRequest:
+		pdus, err = a.getNext(request.Variables)
+	case GetBulkRequest:
+		if request.Version == Version1 {
+			return 0, nil, nil, fmt.Errorf("GetBulk isn't valid in SNMPv1")
+		}
+		pdus, err = a.getBulk(request.Variables, int(request.NonRepeaters), int(request.MaxRepetitions))
+	case SetRequest:
+		pdus, err = a.set(request.Variables)
+	default:
+		return 0, nil, nil, fmt.Errorf("unexpected %#x PDU", request.PDUType)
+	}
+
+	// SNMPv1 has no exceptions, just the noSuchName error
+	if err == nil && request.Version == Version1 {
+		for i, pdu := range pdus {
+			switch pdu.Type {
+			case NoSuchObject, NoSuchInstance, EndOfMibView:
+				err = &varbindError{i, HandlerError{NoSuchName}}
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		// an error response repeats the request's varbinds
+		status, index := errorStatus(err)
+		if request.Version == Version1 {
+			status = v1ErrorStatus(status)
+		}
+		response.Error = status
+		response.ErrorIndex = uint32(index)
+		pdus = request.Variables
+	}
+	return request.PDUType, response, pdus, nil
+}
+
+// mkResponse builds the response packet for request
+func (a *Agent) mkResponse(request *SnmpPacket) *SnmpPacket {
+	response := &SnmpPacket{
+		Version:         request.Version,
+		Community:       request.Community,
+		MsgFlags:        request.MsgFlags &^ Reportable,
+		SecurityModel:   request.SecurityModel,
+		ContextEngineID: request.ContextEngineID,
+		ContextName:     request.ContextName,
+		PDUType:         GetResponse,
+		MsgID:           request.MsgID,
+		RequestID:       request.RequestID,
+	}
+	if request.Version == Version3 {
+		sp := a.engine()
+		response.SecurityParameters = &sp
+	}
+	return response
+}
+
+// report answers an SNMPv3 request with the usmStats counter oid and the
+// agent's engine ID, boots and time: usmStatsUnknownEngineIDs for a request
+// for an unknown engine ID - RFC 3414 3.2 step 3 - or, authenticated,
+// usmStatsNotInTimeWindows for one outside the time window - step 7a
+func (a *Agent) report(header *SnmpPacket, oid string, count uint32) (*SnmpPacket, []SnmpPDU, error) {
+	received := header.SecurityParameters
+	if header.MsgFlags&Reportable == 0 {
+		return nil, nil, fmt.Errorf("%s: engine ID %x boots %d time %d", usmStatsErrors[oid],
+			received.AuthoritativeEngineID, received.AuthoritativeEngineBoots, received.AuthoritativeEngineTime)
+	}
+	sp := a.engine()
+	sp.UserName = received.UserName
+	flags := NoAuthNoPriv
+	if oid == usmStatsNotInTimeWindows {
+		flags = AuthNoPriv
+	}
+	response := &SnmpPacket{
+		Version:            Version3,
+		MsgFlags:           flags,
+		SecurityModel:      UserSecurityModel,
+		SecurityParameters: &sp,
+		PDUType:            Report,
+		MsgID:              header.MsgID,
+	}
+	return response, []SnmpPDU{{Name: oid, Type: Counter32, Value: count}}, nil
+}
+
+// engine returns a copy of the agent's security parameters, with its engine
+// time brought up to date
+func (a *Agent) engine() UsmSecurityParameters {
+	a.Params.secMu.Lock()
+	defer a.Params.secMu.Unlock()
+	sp := *a.Params.session()
+	sp.refreshEngineTime()
+	return sp
+}
+
+// inTimeWindow reports whether the engine boots and time of an authenticated
+// request are within the agent's time window: boots must match, and not have
+// reached their maximum, and time must be within timeWindow of the agent's
+func (a *Agent) inTimeWindow(received *UsmSecurityParameters) bool {
+	sp := a.engine()
+	if sp.AuthoritativeEngineBoots == math.MaxInt32 ||
+		received.AuthoritativeEngineBoots != sp.AuthoritativeEngineBoots {
+		return false
+	}
+	diff := int64(received.AuthoritativeEngineTime) - int64(sp.AuthoritativeEngineTime)
+	return diff >= -timeWindow && diff <= timeWindow
+}
+
+// varbindError is the error for the varbind at index (from 0) of a request
+type varbindError struct {
+	index int
+	err   error
+}
+
+func (e *varbindError) Error() string {
+	return fmt.Sprintf("varbind %d: %s", e.index+1, e.err.Error())
+}
+
+// errorStatus returns the error-status and error-index of a response for err
+func errorStatus(err error) (status ErrorStatus, index int) {
+	if vbErr, ok := err.(*varbindError); ok {
+		index = vbErr.index + 1
+		err = vbErr.err
+	}
+	if handlerErr, ok := err.(HandlerError); ok && handlerErr.Status != NoError {
+		return handlerErr.Status, index
+	}
+	return GenErr, index
+}
+
+// v1ErrorStatus maps SNMPv2 error-status values onto those of SNMPv1 -
+// RFC 3584 4.4
+func v1ErrorStatus(status ErrorStatus) ErrorStatus {
+	switch status {
+	case NoAccess, NotWritable, NoCreation, InconsistentName, AuthorizationError:
+		return NoSuchName
+	case WrongType, WrongLength, WrongEncoding, WrongValue, InconsistentValue:
+		return BadValue
+	case ResourceUnavailable, CommitFailed, UndoFailed:
+		return GenErr
+	}
+	return status
+}
+
+// lookup returns the registration serving name, or nil
+func (a *Agent) lookup(name string) *mibRegistration {
+	oid, err := ParseOID(name)
+	if err != nil {
+		return nil
+	}
+	a.handlersMu.RLock()
+	defer a.handlersMu.RUnlock()
+	for i := range a.handlers {
+		if oid.HasPrefix(a.handlers[i].subtree) {
+			return &a.handlers[i]
+		}
+	}
+	return nil
+}
+
+// get answers a GetRequest
+func (a *Agent) get(vbs []SnmpPDU) ([]SnmpPDU, error) {
+	pdus := make([]SnmpPDU, len(vbs))
+	for i, vb := range vbs {
+		r := a.lookup(vb.Name)
+		if r == nil {
+			pdus[i] = SnmpPDU{Name: vb.Name, Type: NoSuchObject, Value: nil}
+			continue
+		}
+		pdu, err := r.handler.Get(vb.Name)
+		if err != nil {
+			return nil, &varbindError{i, err}
+		}
+		pdu.Name = vb.Name
+		pdus[i] = pdu
+	}
+	return pdus, nil
+}
+
+// next returns the variable following name across all the handlers, or an
+// EndOfMibView PDU
+func (a *Agent) next(name string) (SnmpPDU, error) {
+	oid, err := ParseOID(name)
+	if err != nil {
+		return SnmpPDU{Name: name, Type: EndOfMibView, Value: nil}, nil
+	}
+	a.handlersMu.RLock()
+	handlers := a.handlers
+	a.handlersMu.RUnlock()
+
+	for _, r := range handlers {
+		// skip subtrees that are entirely before oid
+		if r.subtree.Less(oid) && !oid.HasPrefix(r.subtree) {
+			continue
+		}
+		pdu, err := r.handler.GetNext(name)
+		if err != nil {
+			return pdu, err
+		}
+		if pdu.Type == EndOfMibView {
+			continue
+		}
+		// a handler returning a variable out of order or outside its
+		// subtree would make walks loop, so is treated as having no more
+		if next, err := pdu.OID(); err == nil && oid.Less(next) &&
+			next.HasPrefix(r.subtree) {
+			return pdu, nil
+		}
+	}
+	return SnmpPDU{Name: name, Type: EndOfMibView, Value: nil}, nil
+}
+
+// getNext answers a GetNextRequest
+func (a *Agent) getNext(vbs []SnmpPDU) ([]SnmpPDU, error) {
+	pdus := make([]SnmpPDU, len(vbs))
+	for i, vb := range vbs {
+		pdu, err := a.next(vb.Name)
+		if err != nil {
+			return nil, &varbindError{i, err}
+		}
+		pdus[i] = pdu
+	}
+	return pdus, nil
+}
+
+// getBulk answers a GetBulkRequest - RFC 3416 4.2.3: a GetNext of the first
+// nonRepeaters varbinds, then up to maxRepetitions GetNexts of the rest.
+// Repetitions stop once the response would be larger than maxResponseSize,
+// however large maxRepetitions is; tooBig trims the excess.
+func (a *Agent) getBulk(vbs []SnmpPDU, nonRepeaters, maxRepetitions int) ([]SnmpPDU, error) {
+	if nonRepeaters > len(vbs) {
+		nonRepeaters = len(vbs)
+	}
+	pdus, err := a.getNext(vbs[:nonRepeaters])
+	if err != nil {
+		return nil, err
+	}
+
+	repeaters := vbs[nonRepeaters:]
+	last := make([]string, len(repeaters))
+	for i, vb := range repeaters {
+		last[i] = vb.Name
+	}
+	size := estimatedSize(pdus)
+	for r := 0; r < maxRepetitions && len(repeaters) > 0 && size <= maxResponseSize; r++ {
+		ended := true
+		for i := range repeaters {
+			pdu, err := a.next(last[i])
+			if err != nil {
+				return nil, &varbindError{nonRepeaters + i, err}
+			}
+			if pdu.Type != EndOfMibView {
+				ended = false
+				last[i] = pdu.Name
+			}
+			pdus = append(pdus, pdu)
+			size += estimatedSize(pdus[len(pdus)-1:])
+		}
+		if ended {
+			break
+		}
+	}
+	return pdus, nil
+}
+
+// set answers a SetRequest, setting all its varbinds or none - RFC 3416
+// 4.2.5. Every varbind must have a handler and pass its Test before any is
+// set. The varbinds are then Set in order; if one fails, those already set
+// are restored to the values Get returned for them.
+func (a *Agent) set(vbs []SnmpPDU) ([]SnmpPDU, error) {
+	registrations := make([]*mibRegistration, len(vbs))
+	for i, vb := range vbs {
+		if registrations[i] = a.lookup(vb.Name); registrations[i] == nil {
+			return nil, &varbindError{i, HandlerError{NotWritable}}
+		}
+		if err := registrations[i].handler.Test(vb); err != nil {
+			return nil, &varbindError{i, err}
+		}
+	}
+	previous := make([]SnmpPDU, len(vbs))
+	for i, vb := range vbs {
+		handler := registrations[i].handler
+		var err error
+		if previous[i], err = handler.Get(vb.Name); err == nil {
+			err = handler.Set(vb)
+		}
+		if err != nil {
+			return nil, &varbindError{i, HandlerError{undo(registrations[:i], previous[:i])}}
+		}
+	}
+	return vbs, nil
+}
+
+// undo restores the varbinds set before a Set failed to their previous
+// values, returning the error-status of the request: CommitFailed, or
+// UndoFailed if a value couldn't be restored, eg one the Set created
+func undo(registrations []*mibRegistration, previous []SnmpPDU) ErrorStatus {
+	status := CommitFailed
+	for i := len(previous) - 1; i >= 0; i-- {
+		switch previous[i].Type {
+		case NoSuchObject, NoSuchInstance, EndOfMibView:
+			status = UndoFailed
+			continue
+		}
+		if err := registrations[i].handler.Set(previous[i]); err != nil {
+			status = UndoFailed
+		}
+	}
+	return status
+}
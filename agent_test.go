@@ -0,0 +1,521 @@
+// Copyright 2012-2014 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testMIB is a MIBHandler serving a map of variables, read-only unless
+// writable. Setting failSet fails in Set, having passed Test.
+type testMIB struct {
+	mu        sync.Mutex
+	subtree   string
+	variables map[string]SnmpPDU
+	writable  bool
+	failSet   string
+}
+
+func newTestMIB(subtree string, writable bool, pdus ...SnmpPDU) *testMIB {
+	m := &testMIB{subtree: subtree, variables: make(map[string]SnmpPDU), writable: writable}
+	for _, pdu := range pdus {
+		m.variables[pdu.Name] = pdu
+	}
+	return m
+}
+
+func (m *testMIB) Get(oid string) (SnmpPDU, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if pdu, ok := m.variables[oid]; ok {
+		return pdu, nil
+	}
+	return SnmpPDU{Name: oid, Type: NoSuchInstance, Value: nil}, nil
+}
+
+func (m *testMIB) GetNext(oid string) (SnmpPDU, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	after := MustParseOID(oid)
+	var names []OID
+	for name := range m.variables {
+		if n := MustParseOID(name); after.Less(n) {
+			names = append(names, n)
+		}
+	}
+	if len(names) == 0 {
+		return SnmpPDU{Name: oid, Type: EndOfMibView, Value: nil}, nil
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i].Less(names[j]) })
+	return m.variables[names[0].String()], nil
+}
+
+func (m *testMIB) Test(pdu SnmpPDU) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.test(pdu)
+}
+
+func (m *testMIB) test(pdu SnmpPDU) error {
+	if !m.writable {
+		return HandlerError{NotWritable}
+	}
+	if old, ok := m.variables[pdu.Name]; ok && old.Type != pdu.Type {
+		return HandlerError{WrongType}
+	}
+	return nil
+}
+
+func (m *testMIB) Set(pdu SnmpPDU) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.test(pdu); err != nil {
+		return err
+	}
+	if pdu.Name == m.failSet {
+		return fmt.Errorf("unable to set %s", pdu.Name)
+	}
+	m.variables[pdu.Name] = pdu
+	return nil
+}
+
+// startAgent runs an Agent serving a read-only and a writable subtree on a
+// loopback port
+func startAgent(t *testing.T, params *GoSNMP) (*Agent, *testMIB) {
+	system := newTestMIB(".1.3.6.1.2.1.1", false,
+		SnmpPDU{Name: ".1.3.6.1.2.1.1.1.0", Type: OctetString, Value: "gosnmp agent"},
+		SnmpPDU{Name: ".1.3.6.1.2.1.1.3.0", Type: TimeTicks, Value: 4200},
+		SnmpPDU{Name: ".1.3.6.1.2.1.1.5.0", Type: OctetString, Value: "test"},
+	)
+	app := newTestMIB(".1.3.6.1.4.1.99999", true,
+		SnmpPDU{Name: ".1.3.6.1.4.1.99999.1.0", Type: Counter32, Value: uint(17)},
+		SnmpPDU{Name: ".1.3.6.1.4.1.99999.2.0", Type: OctetString, Value: "idle"},
+		SnmpPDU{Name: ".1.3.6.1.4.1.99999.10.0", Type: Integer, Value: 5},
+	)
+
+	agent := NewAgent()
+	agent.Params = params
+	if err := agent.Register(app.subtree, app); err != nil {
+		t.Fatalf("Register() err returned: %v", err)
+	}
+	if err := agent.Register(system.subtree, system); err != nil {
+		t.Fatalf("Register() err returned: %v", err)
+	}
+	errch := make(chan error, 1)
+	go func() {
+		errch <- agent.Listen("127.0.0.1:0")
+	}()
+	select {
+	case <-agent.Listening():
+	case err := <-errch:
+		t.Fatalf("Listen() err returned: %v", err)
+	}
+	return agent, app
+}
+
+// agentClient returns a GoSNMP connected to the agent
+func agentClient(t *testing.T, agent *Agent, version SnmpVersion) *GoSNMP {
+	x := &GoSNMP{
+		Target:    "127.0.0.1",
+		Port:      uint16(agent.conn.LocalAddr().(*net.UDPAddr).Port),
+		Community: "public",
+		Version:   version,
+		Timeout:   time.Duration(2) * time.Second,
+		Retries:   1,
+	}
+	if version == Version3 {
+		x.MsgFlags = AuthPriv
+		x.SecurityParameters = &UsmSecurityParameters{
+			UserName:                 "gosnmp",
+			AuthenticationProtocol:   SHA,
+			AuthenticationPassphrase: "authpassword",
+			PrivacyProtocol:          AES,
+			PrivacyPassphrase:        "privpassword",
+		}
+	}
+	if err := x.Connect(); err != nil {
+		t.Fatalf("Connect() err returned: %v", err)
+	}
+	return x
+}
+
+func pduString(pdus []SnmpPDU) string {
+	var s []string
+	for _, pdu := range pdus {
+		s = append(s, fmt.Sprintf("%s=%#x:%v", pdu.Name, pdu.Type, pdu.Value))
+	}
+	return strings.Join(s, " ")
+}
+
+func TestAgentRegisterOverlap(t *testing.T) {
+	agent := NewAgent()
+	if err := agent.Register(".1.3.6.1.4.1.99999", newTestMIB("", false)); err != nil {
+		t.Fatalf("Register() err returned: %v", err)
+	}
+	for _, subtree := range []string{".1.3.6.1.4.1.99999.1", ".1.3.6.1.4.1", "1.3.6.1.4.1.99999."} {
+		if err := agent.Register(subtree, newTestMIB("", false)); err == nil {
+			t.Errorf("Register(%s) expected an overlap error", subtree)
+		}
+	}
+	if err := agent.Register(".1.3.6.1.4.1.999990", newTestMIB("", false)); err != nil {
+		t.Errorf("Register() of a sibling err returned: %v", err)
+	}
+}
+
+var testsAgentV2c = []struct {
+	pduType  PDUType
+	oids     []string
+	expected string
+}{
+	{GetRequest, []string{".1.3.6.1.2.1.1.5.0", ".1.3.6.1.2.1.1.2.0", ".1.3.6.1.3.1"},
+		".1.3.6.1.2.1.1.5.0=0x4:test .1.3.6.1.2.1.1.2.0=0x81:<nil> .1.3.6.1.3.1=0x80:<nil>"},
+	// GetNext within, into, across and beyond the subtrees
+	{GetNextRequest, []string{".1.3.6.1.2.1.1.1.0", ".1.3.6.1.2.1", ".1.3.6.1.2.1.1.5.0", ".1.3.6.1.4.1.99999.10.0"},
+		".1.3.6.1.2.1.1.3.0=0x43:4200 .1.3.6.1.2.1.1.1.0=0x4:gosnmp agent " +
+			".1.3.6.1.4.1.99999.1.0=0x41:17 .1.3.6.1.4.1.99999.10.0=0x82:<nil>"},
+	// one non-repeater, then 3 repetitions
+	{GetBulkRequest, []string{".1.3.6.1.2.1.1.3.0", ".1.3.6.1.4.1.99999.1.0", ".1.3.6.1.4.1.99999.2.0"},
+		".1.3.6.1.2.1.1.5.0=0x4:test " +
+			".1.3.6.1.4.1.99999.2.0=0x4:idle .1.3.6.1.4.1.99999.10.0=0x2:5 " +
+			".1.3.6.1.4.1.99999.10.0=0x2:5 .1.3.6.1.4.1.99999.10.0=0x82:<nil> " +
+			".1.3.6.1.4.1.99999.10.0=0x82:<nil> .1.3.6.1.4.1.99999.10.0=0x82:<nil>"},
+}
+
+func TestAgentV2c(t *testing.T) {
+	agent, _ := startAgent(t, &GoSNMP{Community: "public"})
+	defer agent.Close()
+	x := agentClient(t, agent, Version2c)
+	defer x.Conn.Close()
+
+	for i, test := range testsAgentV2c {
+		var result *SnmpPacket
+		var err error
+		switch test.pduType {
+		case GetRequest:
+			result, err = x.Get(test.oids)
+		case GetNextRequest:
+			result, err = x.GetNext(test.oids)
+		case GetBulkRequest:
+			result, err = x.GetBulk(test.oids, 1, 3)
+		}
+		if err != nil {
+			t.Errorf("%d: %#x err returned: %v", i, test.pduType, err)
+			continue
+		}
+		if got := pduString(result.Variables); got != test.expected || result.Error != NoError {
+			t.Errorf("%d: %#x got error %d |%s| expected |%s|", i, test.pduType, result.Error, got, test.expected)
+		}
+	}
+}
+
+func TestAgentWalk(t *testing.T) {
+	agent, _ := startAgent(t, &GoSNMP{Community: "public"})
+	defer agent.Close()
+	x := agentClient(t, agent, Version2c)
+	defer x.Conn.Close()
+
+	for _, walk := range []func(string) ([]SnmpPDU, error){x.WalkAll, x.BulkWalkAll} {
+		results, err := walk(".1.3.6.1.4.1.99999")
+		if err != nil {
+			t.Fatalf("walk err returned: %v", err)
+		}
+		expected := ".1.3.6.1.4.1.99999.1.0=0x41:17 .1.3.6.1.4.1.99999.2.0=0x4:idle .1.3.6.1.4.1.99999.10.0=0x2:5"
+		if got := pduString(results); got != expected {
+			t.Errorf("walk got |%s| expected |%s|", got, expected)
+		}
+	}
+}
+
+func TestAgentSet(t *testing.T) {
+	agent, app := startAgent(t, &GoSNMP{Community: "public"})
+	defer agent.Close()
+	x := agentClient(t, agent, Version2c)
+	defer x.Conn.Close()
+
+	_, err := x.Set([]SnmpPDU{
+		{Name: ".1.3.6.1.4.1.99999.2.0", Type: OctetString, Value: "busy"},
+		{Name: ".1.3.6.1.4.1.99999.11.0", Type: Integer, Value: 3},
+	})
+	if err != nil {
+		t.Fatalf("Set() err returned: %v", err)
+	}
+	status, _ := app.Get(".1.3.6.1.4.1.99999.2.0")
+	level, _ := app.Get(".1.3.6.1.4.1.99999.11.0")
+	if fmt.Sprint(status.Value) != "busy" || level.Value != 3 {
+		t.Errorf("Set() got variables %v %v", status, level)
+	}
+
+	var testsSetErrors = []struct {
+		pdus   []SnmpPDU
+		status ErrorStatus
+		index  uint32
+	}{
+		{[]SnmpPDU{{Name: ".1.3.6.1.4.1.99999.2.0", Type: OctetString, Value: "x"}, {Name: ".1.3.6.1.2.1.1.5.0", Type: OctetString, Value: "x"}}, NotWritable, 2},
+		{[]SnmpPDU{{Name: ".1.3.6.1.4.1.99999.10.0", Type: OctetString, Value: "x"}}, WrongType, 1},
+		{[]SnmpPDU{{Name: ".1.3.6.1.3.1", Type: Integer, Value: 1}}, NotWritable, 1},
+	}
+	for i, test := range testsSetErrors {
+		result, err := x.Set(test.pdus)
+		if err == nil || result == nil {
+			t.Errorf("%d: Set() expected an error", i)
+			continue
+		}
+		if result.Error != test.status || result.ErrorIndex != test.index {
+			t.Errorf("%d: Set() got error %d index %d, expected %d index %d",
+				i, result.Error, result.ErrorIndex, test.status, test.index)
+		}
+	}
+	// the first varbind of #0 passed its Test, but mustn't have been set
+	if status, _ = app.Get(".1.3.6.1.4.1.99999.2.0"); fmt.Sprint(status.Value) != "busy" {
+		t.Errorf("Set() that failed at varbind 2 set varbind 1 to %v", status.Value)
+	}
+
+	// a Set failing once others have been set undoes them
+	app.mu.Lock()
+	app.failSet = ".1.3.6.1.4.1.99999.11.0"
+	app.mu.Unlock()
+	var testsSetUndo = []struct {
+		pdus   []SnmpPDU
+		status ErrorStatus
+	}{
+		{[]SnmpPDU{{Name: ".1.3.6.1.4.1.99999.2.0", Type: OctetString, Value: "x"}, {Name: ".1.3.6.1.4.1.99999.11.0", Type: Integer, Value: 4}}, CommitFailed},
+		{[]SnmpPDU{{Name: ".1.3.6.1.4.1.99999.12.0", Type: Integer, Value: 1}, {Name: ".1.3.6.1.4.1.99999.11.0", Type: Integer, Value: 4}}, UndoFailed},
+	}
+	for i, test := range testsSetUndo {
+		result, err := x.Set(test.pdus)
+		if err == nil || result == nil {
+			t.Errorf("%d: Set() expected an error", i)
+			continue
+		}
+		if result.Error != test.status || result.ErrorIndex != 2 {
+			t.Errorf("%d: Set() got error %d index %d, expected %d index 2",
+				i, result.Error, result.ErrorIndex, test.status)
+		}
+	}
+	if status, _ = app.Get(".1.3.6.1.4.1.99999.2.0"); fmt.Sprint(status.Value) != "busy" {
+		t.Errorf("Set() that failed at varbind 2 left varbind 1 set to %v", status.Value)
+	}
+}
+
+func TestAgentV1(t *testing.T) {
+	agent, _ := startAgent(t, &GoSNMP{Community: "public"})
+	defer agent.Close()
+	x := agentClient(t, agent, Version1)
+	defer x.Conn.Close()
+
+	result, err := x.Get([]string{".1.3.6.1.2.1.1.5.0", ".1.3.6.1.2.1.1.2.0"})
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) {
+		t.Fatalf("Get() got err %v, expected a *RequestError", err)
+	}
+	if result.Error != NoSuchName || result.ErrorIndex != 2 {
+		t.Errorf("Get() got error %d index %d, expected noSuchName index 2", result.Error, result.ErrorIndex)
+	}
+
+	// wrongType is badValue in SNMPv1
+	result, err = x.Set([]SnmpPDU{{Name: ".1.3.6.1.4.1.99999.10.0", Type: OctetString, Value: "x"}})
+	if err == nil || result == nil || result.Error != BadValue || result.ErrorIndex != 1 {
+		t.Errorf("Set() got %v, expected badValue index 1", result)
+	}
+}
+
+func TestAgentCommunity(t *testing.T) {
+	agent, _ := startAgent(t, &GoSNMP{Community: "secret"})
+	defer agent.Close()
+	x := agentClient(t, agent, Version2c)
+	defer x.Conn.Close()
+	x.Timeout = time.Duration(200) * time.Millisecond
+
+	if _, err := x.Get([]string{".1.3.6.1.2.1.1.5.0"}); err == nil {
+		t.Errorf("Get() with the wrong community expected a timeout")
+	}
+}
+
+func TestAgentV3(t *testing.T) {
+	params := &GoSNMP{
+		MsgFlags: AuthPriv,
+		SecurityParameters: &UsmSecurityParameters{
+			AuthoritativeEngineID:    "\x80\x00\x1f\x88\x04gosnmp-agent",
+			AuthoritativeEngineBoots: 3,
+			AuthoritativeEngineTime:  100,
+			UserName:                 "gosnmp",
+			AuthenticationProtocol:   SHA,
+			AuthenticationPassphrase: "authpassword",
+			PrivacyProtocol:          AES,
+			PrivacyPassphrase:        "privpassword",
+		},
+	}
+	agent, _ := startAgent(t, params)
+	defer agent.Close()
+
+	// Connect discovers the agent's engine
+	x := agentClient(t, agent, Version3)
+	defer x.Conn.Close()
+	usm := x.usm
+	if usm.AuthoritativeEngineID != params.SecurityParameters.AuthoritativeEngineID ||
+		usm.AuthoritativeEngineBoots != 3 || usm.AuthoritativeEngineTime < 100 {
+		t.Errorf("discovery got engine ID %q boots %d time %d",
+			usm.AuthoritativeEngineID, usm.AuthoritativeEngineBoots, usm.AuthoritativeEngineTime)
+	}
+
+	result, err := x.Get([]string{".1.3.6.1.2.1.1.1.0"})
+	if err != nil {
+		t.Fatalf("Get() err returned: %v", err)
+	}
+	if result.MsgFlags != AuthPriv || len(result.Variables) != 1 || fmt.Sprint(result.Variables[0].Value) != "gosnmp agent" {
+		t.Errorf("Get() got flags %d variables %v", result.MsgFlags, result.Variables)
+	}
+}
+
+var testsAgentTimeWindow = []struct {
+	boots    uint32
+	time     uint32
+	inWindow bool
+}{
+	{3, 1000, true},
+	{3, 1100, true},
+	{3, 900, true},
+	{3, 1200, false},
+	{3, 800, false},
+	{2, 1000, false},
+	{4, 1000, false},
+}
+
+func TestAgentV3TimeWindow(t *testing.T) {
+	const engineID = "\x80\x00\x1f\x88\x04gosnmp-agent"
+	params := &GoSNMP{
+		MsgFlags: AuthNoPriv,
+		SecurityParameters: &UsmSecurityParameters{
+			AuthoritativeEngineID:    engineID,
+			AuthoritativeEngineBoots: 3,
+			AuthoritativeEngineTime:  1000,
+			UserName:                 "gosnmp",
+			AuthenticationProtocol:   SHA,
+			AuthenticationPassphrase: "authpassword",
+		},
+	}
+	agent, _ := startAgent(t, params)
+	defer agent.Close()
+	conn, err := net.DialUDP("udp", nil, agent.conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("DialUDP() err returned: %v", err)
+	}
+	defer conn.Close()
+
+	for i, test := range testsAgentTimeWindow {
+		x := &GoSNMP{
+			Version:       Version3,
+			MsgFlags:      AuthNoPriv,
+			SecurityModel: UserSecurityModel,
+			SecurityParameters: &UsmSecurityParameters{
+				AuthoritativeEngineID:    engineID,
+				AuthoritativeEngineBoots: test.boots,
+				AuthoritativeEngineTime:  test.time,
+				UserName:                 "gosnmp",
+				AuthenticationProtocol:   SHA,
+				AuthenticationPassphrase: "authpassword",
+			},
+		}
+		request := x.mkSnmpPacket(GetRequest, 0, 0)
+		request.MsgID = uint32(i + 1)
+		msg, err := request.marshalMsg([]SnmpPDU{{Name: ".1.3.6.1.2.1.1.1.0", Type: Null, Value: nil}}, GetRequest, uint32(i+1))
+		if err != nil {
+			t.Fatalf("#%d: marshalMsg() err returned: %v", i, err)
+		}
+		if _, err = conn.Write(msg); err != nil {
+			t.Fatalf("#%d: Write() err returned: %v", i, err)
+		}
+		buf := make([]byte, rxBufSize)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("#%d: Read() err returned: %v", i, err)
+		}
+		response, err := x.unmarshalResponse(buf[:n])
+		if err != nil {
+			t.Fatalf("#%d: unmarshalResponse() err returned: %v", i, err)
+		}
+
+		if test.inWindow {
+			if response.PDUType != GetResponse {
+				t.Errorf("#%d: boots %d time %d got PDU type %#x, expected a response",
+					i, test.boots, test.time, response.PDUType)
+			}
+			continue
+		}
+		received := response.SecurityParameters
+		if response.PDUType != Report || len(response.Variables) != 1 ||
+			response.Variables[0].Name != usmStatsNotInTimeWindows {
+			t.Errorf("#%d: boots %d time %d got PDU type %#x variables %v, expected a usmStatsNotInTimeWindows report",
+				i, test.boots, test.time, response.PDUType, response.Variables)
+		} else if response.MsgFlags&AuthNoPriv == 0 {
+			t.Errorf("#%d: got an unauthenticated report", i)
+		} else if received.AuthoritativeEngineBoots != 3 || received.AuthoritativeEngineTime < 1000 ||
+			received.AuthoritativeEngineTime > 1010 {
+			t.Errorf("#%d: report got boots %d time %d, expected the agent's",
+				i, received.AuthoritativeEngineBoots, received.AuthoritativeEngineTime)
+		}
+	}
+}
+
+// countingMIB is a MIBHandler with no end: GetNext of .1.3.6.1.4.1.99999.n
+// returns .1.3.6.1.4.1.99999.n+1, valued with 100 octets
+type countingMIB struct{}
+
+func (countingMIB) Get(oid string) (SnmpPDU, error) {
+	return SnmpPDU{Name: oid, Type: NoSuchInstance, Value: nil}, nil
+}
+
+func (countingMIB) GetNext(oid string) (SnmpPDU, error) {
+	n := 0
+	if index := strings.TrimPrefix(oid, ".1.3.6.1.4.1.99999."); index != oid {
+		n, _ = strconv.Atoi(strings.SplitN(index, ".", 2)[0])
+	}
+	return SnmpPDU{Name: fmt.Sprintf(".1.3.6.1.4.1.99999.%d", n+1), Type: OctetString, Value: strings.Repeat("x", 100)}, nil
+}
+
+func (countingMIB) Test(pdu SnmpPDU) error {
+	return HandlerError{NotWritable}
+}
+
+func (countingMIB) Set(pdu SnmpPDU) error {
+	return HandlerError{NotWritable}
+}
+
+func TestAgentGetBulkMaxRepetitions(t *testing.T) {
+	agent := NewAgent()
+	if err := agent.Register(".1.3.6.1.4.1.99999", countingMIB{}); err != nil {
+		t.Fatalf("Register() err returned: %v", err)
+	}
+	repeaters := []SnmpPDU{{Name: ".1.3.6.1.4.1.99999", Type: Null, Value: nil}, {Name: ".1.3.6.1.4.1.99999.5", Type: Null, Value: nil}}
+	pdus, err := agent.getBulk(repeaters, 0, math.MaxInt32)
+	if err != nil {
+		t.Fatalf("getBulk() err returned: %v", err)
+	}
+	if len(pdus) > maxResponseSize/100 {
+		t.Errorf("getBulk() got %d varbinds, expected no more than fit in a response", len(pdus))
+	}
+
+	response := &SnmpPacket{Version: Version2c, Community: "public", PDUType: GetResponse, RequestID: 1}
+	out, err := response.marshalMsg(pdus, GetResponse, 1)
+	if err == nil && len(out) > maxResponseSize {
+		out, err = tooBig(GetBulkRequest, response, pdus)
+	}
+	if err != nil {
+		t.Fatalf("marshalMsg() err returned: %v", err)
+	}
+	if len(out) > maxResponseSize || response.Error != NoError {
+		t.Errorf("got a %d octet response with error-status %d", len(out), response.Error)
+	}
+}
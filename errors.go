@@ -0,0 +1,58 @@
+// Copyright 2012-2014 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors wrapped by the errors of requests, for use with errors.Is. See also
+// ErrTruncated and ErrDecryption.
+var (
+	// ErrTimeout is wrapped when a request has no response in time
+	ErrTimeout = errors.New("Request timeout")
+
+	// ErrDecode is wrapped when a response can't be decoded
+	ErrDecode = errors.New("Unable to decode packet")
+
+	// ErrOutOfOrder is wrapped when a response doesn't answer the request
+	// it's matched to eg an SNMPv3 response with another request ID
+	ErrOutOfOrder = errors.New("Received an out of order response")
+
+	// ErrAuthentication is wrapped when an SNMPv3 response fails
+	// authentication, or the agent reports that a request failed it
+	ErrAuthentication = errors.New("Authentication failed")
+)
+
+// RequestError is the error returned by Get, GetNext, GetBulk and Set when the
+// agent responds with an error-status. The response is returned too. Walks and
+// GetTable return it when a request of theirs fails with an error-status.
+type RequestError struct {
+	Status  ErrorStatus // Status is the error-status eg NoSuchName
+	Index   int         // Index is the error-index, from 1, or 0 if the error isn't specific to a varbind
+	Varbind *SnmpPDU    // Varbind is the varbind of the request at Index, if any
+}
+
+func (e *RequestError) Error() string {
+	if e.Varbind != nil {
+		return fmt.Sprintf("Request failed with error-status %s at varbind %d (%s)",
+			e.Status, e.Index, e.Varbind.Name)
+	}
+	return fmt.Sprintf("Request failed with error-status %s", e.Status)
+}
+
+// checkStatus returns a *RequestError for a response to a request of pdus
+// with an error-status, along with the response
+func checkStatus(result *SnmpPacket, err error, pdus []SnmpPDU) (*SnmpPacket, error) {
+	if err != nil || result == nil || result.Error == NoError {
+		return result, err
+	}
+	reqErr := &RequestError{Status: result.Error, Index: int(result.ErrorIndex)}
+	if reqErr.Index > 0 && reqErr.Index <= len(pdus) {
+		reqErr.Varbind = &pdus[reqErr.Index-1]
+	}
+	return result, reqErr
+}
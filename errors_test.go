@@ -0,0 +1,255 @@
+// Copyright 2012-2014 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var testsErrorStatus = []struct {
+	status ErrorStatus
+	out    string
+}{
+	{NoError, "noError"},
+	{TooBig, "tooBig"},
+	{NoSuchName, "noSuchName"},
+	{GenErr, "genErr"},
+	{WrongValue, "wrongValue"},
+	{NotWritable, "notWritable"},
+	{InconsistentName, "inconsistentName"},
+	{ErrorStatus(42), "errorStatus(42)"},
+}
+
+func TestErrorStatusString(t *testing.T) {
+	for i, test := range testsErrorStatus {
+		if out := test.status.String(); out != test.out {
+			t.Errorf("#%d: got %q, expected %q", i, out, test.out)
+		}
+	}
+}
+
+var testsRequestError = []struct {
+	status  ErrorStatus
+	index   uint32
+	varbind string
+	out     string
+}{
+	{NoError, 0, "", ""},
+	{NoSuchName, 2, ".1.3.6.1.2.1.1.2.0",
+		"Request failed with error-status noSuchName at varbind 2 (.1.3.6.1.2.1.1.2.0)"},
+	{GenErr, 0, "", "Request failed with error-status genErr"},
+	{TooBig, 3, "", "Request failed with error-status tooBig"},
+}
+
+func TestRequestError(t *testing.T) {
+	oids := []string{".1.3.6.1.2.1.1.1.0", ".1.3.6.1.2.1.1.2.0"}
+	for i, test := range testsRequestError {
+		x := testClient(t, func(request *SnmpPacket) (*SnmpPacket, []SnmpPDU) {
+			return &SnmpPacket{
+				Version:    request.Version,
+				Community:  request.Community,
+				PDUType:    GetResponse,
+				Error:      test.status,
+				ErrorIndex: test.index,
+			}, request.Variables
+		})
+
+		result, err := x.Get(oids)
+		x.Transport.Close()
+		if result == nil {
+			t.Errorf("#%d: Get() got no response, err %v", i, err)
+			continue
+		}
+		if test.out == "" {
+			if err != nil {
+				t.Errorf("#%d: Get() err returned: %v", i, err)
+			}
+			continue
+		}
+		var reqErr *RequestError
+		if !errors.As(err, &reqErr) {
+			t.Errorf("#%d: Get() got err %v, expected a *RequestError", i, err)
+			continue
+		}
+		if reqErr.Status != test.status || reqErr.Index != int(test.index) {
+			t.Errorf("#%d: got status %s index %d, expected %s %d",
+				i, reqErr.Status, reqErr.Index, test.status, test.index)
+		}
+		name := ""
+		if reqErr.Varbind != nil {
+			name = reqErr.Varbind.Name
+		}
+		if name != test.varbind {
+			t.Errorf("#%d: got varbind %q, expected %q", i, name, test.varbind)
+		}
+		if err.Error() != test.out {
+			t.Errorf("#%d: got %q, expected %q", i, err, test.out)
+		}
+	}
+}
+
+func TestWalkRequestError(t *testing.T) {
+	x := testClient(t, func(request *SnmpPacket) (*SnmpPacket, []SnmpPDU) {
+		return &SnmpPacket{
+			Version:    request.Version,
+			Community:  request.Community,
+			PDUType:    GetResponse,
+			Error:      GenErr,
+			ErrorIndex: 1,
+		}, request.Variables
+	})
+	defer x.Transport.Close()
+
+	walks := map[string]func() error{
+		"WalkAll": func() error {
+			_, err := x.WalkAll(".1.3.6.1.2.1.2.2.1.2")
+			return err
+		},
+		"BulkWalkAll": func() error {
+			_, err := x.BulkWalkAll(".1.3.6.1.2.1.2.2.1.2")
+			return err
+		},
+		"BulkWalkColumns": func() error {
+			return x.BulkWalkColumns([]string{".1.3.6.1.2.1.2.2.1.2", ".1.3.6.1.2.1.2.2.1.3"},
+				func(column int, pdu SnmpPDU) error { return nil })
+		},
+		"GetTable": func() error {
+			_, err := x.GetTable(".1.3.6.1.2.1.2.2.1", 2, 3)
+			return err
+		},
+	}
+	for name, walk := range walks {
+		err := walk()
+		var reqErr *RequestError
+		if !errors.As(err, &reqErr) {
+			t.Errorf("%s() got err %v, expected a *RequestError", name, err)
+			continue
+		}
+		if reqErr.Status != GenErr || reqErr.Varbind == nil ||
+			reqErr.Varbind.Name != ".1.3.6.1.2.1.2.2.1.2" {
+			t.Errorf("%s() got %v, expected genErr at varbind 1", name, err)
+		}
+	}
+}
+
+// errorStatusResponse returns a SNMPv2c GetResponse-PDU with no varbinds,
+// and the given encoded error-status
+func errorStatusResponse(status ...byte) []byte {
+	pdu := []byte{0x02, 0x01, 0x01} // request-id
+	pdu = append(pdu, 0x02, byte(len(status)))
+	pdu = append(pdu, status...)
+	pdu = append(pdu, 0x02, 0x01, 0x00, 0x30, 0x00) // error-index, varbinds
+	msg := []byte{0x02, 0x01, 0x01, 0x04, 0x06, 'p', 'u', 'b', 'l', 'i', 'c'}
+	msg = append(msg, byte(GetResponse), byte(len(pdu)))
+	msg = append(msg, pdu...)
+	return append([]byte{0x30, byte(len(msg))}, msg...)
+}
+
+func TestErrorStatusRange(t *testing.T) {
+	x := &GoSNMP{Version: Version2c}
+	result, err := x.unmarshalResponse(errorStatusResponse(0x05))
+	if err != nil || result.Error != GenErr {
+		t.Errorf("genErr got %v, %v", result, err)
+	}
+	// too large for ErrorStatus, rather than truncated to noError
+	for i, status := range [][]byte{{0x01, 0x00}, {0xff}} {
+		if _, err := x.unmarshalResponse(errorStatusResponse(status...)); !errors.Is(err, ErrDecode) {
+			t.Errorf("#%d: error-status % x got err %v, expected %v", i, status, err, ErrDecode)
+		}
+	}
+}
+
+func TestErrTimeout(t *testing.T) {
+	x, conn := silentClient(t)
+	defer conn.Close()
+	defer x.Conn.Close()
+	x.Timeout = time.Duration(100) * time.Millisecond
+
+	if _, err := x.Get([]string{".1.3.6.1.2.1.1.1.0"}); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Get() got err %v, expected %v", err, ErrTimeout)
+	}
+}
+
+func TestErrDecode(t *testing.T) {
+	x := &GoSNMP{Version: Version2c}
+	for i, msg := range [][]byte{{0x30, 0x03, 0x02, 0x01}, {0x04, 0x00}} {
+		if _, err := x.unmarshalResponse(msg); !errors.Is(err, ErrDecode) {
+			t.Errorf("#%d: got err %v, expected %v", i, err, ErrDecode)
+		}
+	}
+}
+
+var testsReportError = []struct {
+	oid   string
+	cause error
+}{
+	{".1.3.6.1.6.3.15.1.1.1.0", ErrAuthentication}, // unsupported security level
+	{".1.3.6.1.6.3.15.1.1.2.0", nil},               // not in time window
+	{".1.3.6.1.6.3.15.1.1.3.0", ErrAuthentication}, // unknown user name
+	{".1.3.6.1.6.3.15.1.1.5.0", ErrAuthentication}, // wrong digest
+	{".1.3.6.1.6.3.15.1.1.6.0", ErrDecryption},     // decryption error
+}
+
+func TestReportError(t *testing.T) {
+	for i, test := range testsReportError {
+		err := reportError(&SnmpPacket{Variables: []SnmpPDU{{Name: test.oid, Type: Counter32, Value: 1}}})
+		if test.cause == nil {
+			if errors.Is(err, ErrAuthentication) || errors.Is(err, ErrDecryption) {
+				t.Errorf("#%d: %s got err %v, expected no cause", i, test.oid, err)
+			}
+		} else if !errors.Is(err, test.cause) {
+			t.Errorf("#%d: %s got err %v, expected %v", i, test.oid, err, test.cause)
+		}
+	}
+}
+
+func TestErrOutOfOrder(t *testing.T) {
+	client, agent := NewPipeTransport()
+	defer client.Close()
+	go func() {
+		for {
+			msg, err := agent.Receive(time.Time{})
+			if err != nil {
+				return
+			}
+			request, err := new(GoSNMP).unmarshal(msg)
+			if err != nil {
+				t.Errorf("agent: unable to decode request: %v", err)
+				return
+			}
+			// the right msgID, but the scopedPDU of another request
+			response := &SnmpPacket{
+				Version:            Version3,
+				MsgFlags:           NoAuthNoPriv,
+				SecurityModel:      UserSecurityModel,
+				SecurityParameters: request.SecurityParameters,
+				PDUType:            GetResponse,
+				MsgID:              request.MsgID,
+			}
+			msg, err = response.marshalMsg(request.Variables, GetResponse, request.RequestID+1)
+			if err != nil {
+				t.Errorf("agent: unable to marshal reply: %v", err)
+				return
+			}
+			agent.Send(msg)
+		}
+	}()
+
+	usm := testUsm(NoAuth)
+	usm.AuthenticationPassphrase = ""
+	x := &GoSNMP{
+		Transport:          client,
+		Version:            Version3,
+		Timeout:            time.Duration(2) * time.Second,
+		MsgFlags:           NoAuthNoPriv,
+		SecurityModel:      UserSecurityModel,
+		SecurityParameters: usm,
+	}
+	if _, err := x.Get([]string{".1.3.6.1.2.1.1.1.0"}); !errors.Is(err, ErrOutOfOrder) {
+		t.Errorf("Get() got err %v, expected %v", err, ErrOutOfOrder)
+	}
+}
@@ -40,7 +40,7 @@ func main() {
 		// interface{}. You could do a type switch...
 		switch variable.Type {
 		case g.OctetString:
-			fmt.Printf("string: %s\n", variable.Value.(string))
+			fmt.Printf("string: %s\n", variable.Value.(g.OctetStringValue))
 		default:
 			// ... or often you're just interested in numeric values.
 			// ToBigInt() will return the Value as a BigInt, for plugging
@@ -53,7 +53,7 @@ func printValue(pdu gosnmp.SnmpPDU) error {
 
 	switch pdu.Type {
 	case gosnmp.OctetString:
-		fmt.Printf("STRING: %s\n", pdu.Value.(string))
+		fmt.Printf("STRING: %s\n", pdu.Value.(gosnmp.OctetStringValue))
 	default:
 		fmt.Printf("TYPE %d: %d\n", pdu.Type, gosnmp.ToBigInt(pdu.Value))
 	}
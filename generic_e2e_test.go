@@ -25,7 +25,7 @@ const (
 
 func setupConnection(t *testing.T) {
 	if len(testTarget) < 1 {
-		t.Skip("Skipping Generic tests! Is %s a valid SNMP host?")
+		t.Skipf("Skipping Generic tests! Is %s a valid SNMP host?", testTarget)
 	}
 	Default.Target = testTarget
 	Default.Port = testPort
@@ -50,7 +50,7 @@ func TestGenericBasicGet(t *testing.T) {
 	if result.Variables[0].Type != OctetString {
 		t.Fatalf("Expected sysDescr to be OctetString")
 	}
-	sysDescr := result.Variables[0].Value.(string)
+	sysDescr := result.Variables[0].Value.(OctetStringValue).String()
 	if len(sysDescr) == 0 {
 		t.Fatalf("Got a zero length sysDescr")
 	}
@@ -9,36 +9,47 @@
 package gosnmp
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
 	"math/big"
 	"math/rand"
 	"net"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 const (
-	maxOids               = 60             // maxOids is the maximum number of oids allowed in a Get()
+	maxOids               = 60             // maxOids is the default maximum number of oids allowed in a request
+	maxRequestSize        = 1472           // maxRequestSize is the default maximum size of a Get request, an Ethernet MTU less IP and UDP headers
 	baseOid               = ".1.3.6.1.2.1" // Base OID for MIB-2 defined SNMP variables
 	defaultMaxRepetitions = 50             // Java SNMP uses 50, snmp-net uses 10
 
 )
 
-// LoggingDisabled is set if the Logger is nil, short circuits any 'slog' calls
+// LoggingDisabled is no longer used: debugging output is written to
+// GoSNMP.Logger, and discarded if it is nil.
+//
+// Deprecated: set GoSNMP.Logger to nil instead.
 var LoggingDisabled bool
 
-// GoSNMP represents GoSNMP library state
+// GoSNMP represents GoSNMP library state. Requests may be made concurrently
+// from many goroutines: they share Conn, and their responses are matched by
+// request ID (msgID for SNMPv3).
 type GoSNMP struct {
 	Target    string        // Target is an ipv4 address
-	Port      uint16        // Port is a udp port
+	Port      uint16        // Port is a udp (or tcp) port
+	Network   string        // Network is "udp" (default) or "tcp", or eg "udp6"
 	Community string        // Community is an SNMP Community string
 	Version   SnmpVersion   // Version is an SNMP Version
+	Negotiate bool          // Negotiate sets Version to the highest the agent supports on Connect
 	Timeout   time.Duration // Timeout is the timeout for the SNMP Query
 	Retries   int           // Set the number of retries to attempt within timeout.
 	Conn      net.Conn      // Conn is net connection to use, typically establised using GoSNMP.Connect()
+	Transport Transport     // Transport, if set, is used in place of Conn eg an in-memory pipe
 
 	// Logger is the GoSNMP.Logger to use for debugging. If nil, debugging
 	// output will be discarded (/dev/null). For verbose logging to stdout:
@@ -46,10 +57,29 @@ type GoSNMP struct {
 	Logger Logger
 
 	MaxRepetitions int        // MaxRepititions sets the GETBULK max-repetitions used by BulkWalk* (default: 50)
+	AdaptiveReps   bool       // AdaptiveReps tunes the max-repetitions of BulkWalk* to each target, from MaxRepetitions
 	NonRepeaters   int        // NonRepeaters sets the GETBULK max-repeaters used by BulkWalk* (default: 0 as per RFC 1905)
+	MaxOids        int        // MaxOids limits the number of oids/varbinds in one request (default: 60)
+	MaxRequestSize int        // MaxRequestSize limits the estimated size of a Get/GetNext request (default: 1472)
+	Concurrency    int        // Concurrency is how many requests of a split Get/GetNext are in flight (default: 1)
 	requestID      uint32     // Internal - used to sync requests to response
+	msgID          uint32     // Internal - used to sync SNMPv3 messages to responses
 	random         *rand.Rand // Internal - used to sync requests to responses
 
+	mu      sync.Mutex                  // Internal - guards pending, reader and reps
+	pending map[uint32]chan muxResponse // Internal - requests awaiting a response, by request ID
+	reader  *responseReader             // Internal - reads responses from Conn
+	reps    map[string]learnedReps      // Internal - max-repetitions learned by AdaptiveReps, by target
+	secMu   sync.Mutex                  // Internal - guards usm
+	usm     *UsmSecurityParameters      // Internal - the SNMPv3 session, see session()
+	usmFrom *UsmSecurityParameters      // Internal - the SecurityParameters usm was copied from
+
+	// SNMPv3 settings, used when Version is Version3. See v3.go.
+	MsgFlags           SnmpV3MsgFlags         // MsgFlags is the security level: NoAuthNoPriv, AuthNoPriv or AuthPriv
+	SecurityModel      SnmpV3SecurityModel    // SecurityModel defaults to UserSecurityModel
+	SecurityParameters *UsmSecurityParameters // SecurityParameters holds the USM user, protocols and passphrases; only read, so may be shared
+	ContextEngineID    string                 // ContextEngineID defaults to the authoritative engine ID
+	ContextName        string                 // ContextName defaults to the default context ""
 }
 
 // The default connection settings
@@ -66,6 +96,8 @@ type SnmpPDU struct {
 	Name  string      // Name is an oid in string format eg ".1.3.6.1.4.9.27"
 	Type  Asn1BER     // The type of the value eg Integer
 	Value interface{} // The value to be set by the SNMP set
+
+	decoded *decodedOID // Internal - the OID of Name, if decoded from a response
 }
 
 // Asn1BER is the type of the SNMP PDU
@@ -99,114 +131,267 @@ const (
 // Public Functions (main interface)
 //
 
-// Connect initiates a connection to the target host
+// Connect initiates a connection to the target host, unless a Transport has
+// been set (eg from a UDPMux). For SNMPv3 the authoritative engine ID, boots
+// and time of the agent are then discovered, unless
+// SecurityParameters.AuthoritativeEngineID has already been set. They're kept
+// by this GoSNMP, not written to SecurityParameters.
+//
+// With Negotiate, Connect probes the agent instead, setting Version to the
+// highest it supports: SNMPv3 if SecurityParameters are set and engine
+// discovery succeeds, else SNMPv2c, else SNMPv1. Get and GetNext results
+// from an SNMPv1 agent are then made to look like SNMPv2c, with noSuchName
+// errors becoming noSuchObject or endOfMibView values.
 func (x *GoSNMP) Connect() error {
-	if x.Logger == nil {
-		LoggingDisabled = true
-	}
-	Conn, err := net.DialTimeout("udp", fmt.Sprintf("%s:%d", x.Target, x.Port), x.Timeout)
-	if err == nil {
-		x.Conn = Conn
-	} else {
-		return fmt.Errorf("Error establishing connection to host: %s\n", err.Error())
+	return x.ConnectContext(context.Background())
+}
+
+// ConnectContext is Connect, stopping with ctx.Err() once ctx is done
+func (x *GoSNMP) ConnectContext(ctx context.Context) error {
+	if x.Transport == nil {
+		Conn, err := x.dial(ctx)
+		if err == nil {
+			x.Conn = Conn
+		} else {
+			return fmt.Errorf("Error establishing connection to host: %s\n", err.Error())
+		}
 	}
 	if x.random == nil {
 		x.random = rand.New(rand.NewSource(time.Now().UTC().UnixNano()))
 	}
 	x.requestID = x.random.Uint32()
+	x.msgID = x.random.Uint32() & 0x7fffffff
+	if x.Negotiate {
+		return x.negotiateVersion(ctx)
+	}
+	if x.Version == Version3 && x.SecurityParameters != nil && x.engineID() == "" {
+		return x.discoverEngine(ctx)
+	}
 	return nil
 }
 
+// negotiateVersion probes the agent for the highest version it supports:
+// SNMPv3 engine discovery, then a GETNEXT with SNMPv2c and SNMPv1. Any
+// response shows the version is supported, even an error-status.
+func (x *GoSNMP) negotiateVersion(ctx context.Context) error {
+	if x.SecurityParameters != nil {
+		x.Version = Version3
+		err := x.discoverEngine(ctx)
+		if err == nil {
+			x.logPrintf("Negotiated SNMPv3")
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		x.logPrintf("SNMPv3 not supported: %v", err)
+	}
+	for _, version := range []SnmpVersion{Version2c, Version1} {
+		x.Version = version
+		_, err := x.getBatched(ctx, GetNextRequest, []string{baseOid})
+		if err == nil {
+			x.logPrintf("Negotiated %s", version)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		x.logPrintf("%s not supported: %v", version, err)
+	}
+	return fmt.Errorf("Unable to negotiate an SNMP version with %s", x.Target)
+}
+
+// dial connects to Target over Network. SNMP over TCP is described in
+// RFC 3430.
+func (x *GoSNMP) dial(ctx context.Context) (net.Conn, error) {
+	network := x.Network
+	switch network {
+	case "":
+		network = "udp"
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("Unsupported network %q", network)
+	}
+	dialer := net.Dialer{Timeout: x.Timeout}
+	return dialer.DialContext(ctx, network, fmt.Sprintf("%s:%d", x.Target, x.Port))
+}
+
 // send sends the SNMP packet generated in the other functions and recieves a result
 func (x *GoSNMP) send(pdus []SnmpPDU, packetOut *SnmpPacket) (result *SnmpPacket, err error) {
+	return x.sendContext(context.Background(), pdus, packetOut)
+}
+
+// sendContext is send, stopping with ctx.Err() once ctx is done. The
+// deadline of ctx shortens Timeout.
+func (x *GoSNMP) sendContext(ctx context.Context, pdus []SnmpPDU, packetOut *SnmpPacket) (result *SnmpPacket, err error) {
 	defer func() {
 		if e := recover(); e != nil {
 			err = fmt.Errorf("recover: %v", e)
 		}
 	}()
 
-	if x.Conn == nil {
+	x.mu.Lock()
+	connected := x.Conn != nil || x.Transport != nil
+	x.mu.Unlock()
+	if !connected {
 		return nil, fmt.Errorf("&GoSNMP.Conn is missing. Provide a connection or use Connect()")
 	}
 
-	if x.Logger == nil {
-		x.Logger = log.New(ioutil.Discard, "", 0)
+	// requests made with the session's security parameters need the
+	// agent's engine ID, discovered on the first request if not on Connect()
+	x.secMu.Lock()
+	sp := x.session()
+	x.secMu.Unlock()
+	session := x.Version == Version3 && sp != nil && packetOut.SecurityParameters == sp
+	if session && x.engineID() == "" {
+		if err = x.discoverEngine(ctx); err != nil {
+			return nil, err
+		}
 	}
-	slog = x.Logger // global variable for debug logging
+	resent := false
 
 	finalDeadline := time.Now().Add(x.Timeout)
+	ctxDeadline := false
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(finalDeadline) {
+		finalDeadline = deadline
+		ctxDeadline = true
+	}
 
-	if x.Retries < 0 {
-		x.Retries = 0
+	maxRetries := x.Retries
+	if maxRetries < 0 {
+		maxRetries = 0
 	}
-	allReqIDs := make([]uint32, 0, x.Retries+1)
+	// responses to any of the tries are accepted
+	responses := make(chan muxResponse, 1)
+	ids := make([]uint32, 0, maxRetries+1)
+	defer func() {
+		x.forget(ids)
+	}()
 	for retries := 0; ; retries++ {
 		if retries > 0 {
-			if LoggingDisabled != true {
-				slog.Printf("Retry number %d. Last error was: %v", retries, err)
-			}
+			x.logPrintf("Retry number %d. Last error was: %v", retries, err)
 			if time.Now().After(finalDeadline) {
-				err = fmt.Errorf("Request timeout (after %d retries)", retries-1)
+				err = fmt.Errorf("%w (after %d retries)", ErrTimeout, retries-1)
 				break
 			}
-			if retries > x.Retries {
+			if retries > maxRetries {
 				// Report last error
 				break
 			}
 		}
 		err = nil
 
-		reqDeadline := time.Now().Add(x.Timeout / time.Duration(x.Retries+1))
-		x.Conn.SetDeadline(reqDeadline)
+		reqDeadline := time.Now().Add(x.Timeout / time.Duration(maxRetries+1))
+		if reqDeadline.After(finalDeadline) {
+			reqDeadline = finalDeadline
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 
-		// Request ID is an atomic counter (started at a random value)
+		// Request ID is an atomic counter (started at a random value).
+		// SNMPv3 messages are matched on msgID, which must be positive.
 		reqID := atomic.AddUint32(&(x.requestID), 1)
-		allReqIDs = append(allReqIDs, reqID)
+		id := reqID
+		if x.Version == Version3 {
+			id = atomic.AddUint32(&(x.msgID), 1) & 0x7fffffff
+			packetOut.MsgID = id
+		}
+		ids = append(ids, id)
+		var reader *responseReader
+		if reader, err = x.await(ctx, id, responses); err != nil {
+			continue
+		}
 
 		var outBuf []byte
+		x.secMu.Lock()
+		if session {
+			sp.refreshEngineTime()
+		}
 		outBuf, err = packetOut.marshalMsg(pdus, packetOut.PDUType, reqID)
+		x.secMu.Unlock()
 		if err != nil {
 			// Don't retry - not going to get any better!
 			err = fmt.Errorf("marshal: %v", err)
 			break
 		}
-		_, err = x.Conn.Write(outBuf)
+		err = reader.transport.Send(outBuf)
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			reader.fail(err)
 			err = fmt.Errorf("Error writing to socket: %s", err.Error())
 			continue
 		}
+		if packetOut.PDUType == Trap || packetOut.PDUType == SNMPv2Trap {
+			// traps are unconfirmed - there's no response to wait for
+			return nil, nil
+		}
 
-		// FIXME: If our packet exceeds our buf size we'll get a partial read
-		// and this request, and the next will fail. The correct logic would be
-		// to realloc and read more if pack len > buff size.
-		resp := make([]byte, rxBufSize, rxBufSize)
-		var n int
-		n, err = x.Conn.Read(resp)
-		if err != nil {
-			err = fmt.Errorf("Error reading from UDP: %s", err.Error())
-			continue
+		var response muxResponse
+		timer := time.NewTimer(time.Until(reqDeadline))
+		select {
+		case response = <-responses:
+		case <-timer.C:
+			if ctxDeadline && !time.Now().Before(finalDeadline) {
+				// ctx is done, or about to be
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			response.err = ErrTimeout
+		case <-reader.done:
+			response.err = fmt.Errorf("Error reading from socket: %s", reader.err.Error())
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
 		}
+		timer.Stop()
 
-		result, err = unmarshal(resp[:n])
+		result, err = response.packet, response.err
+		if errors.Is(err, ErrTruncated) {
+			// Don't retry - the response won't be any smaller
+			break
+		}
 		if err != nil {
-			err = fmt.Errorf("Unable to decode packet: %s", err.Error())
 			continue
 		}
-		if result == nil || len(result.Variables) < 1 {
-			err = fmt.Errorf("Unable to decode packet: nil")
+		// an error response (eg tooBig) may have no varbinds
+		if result == nil || len(result.Variables) < 1 && result.Error == NoError {
+			err = fmt.Errorf("%w: nil", ErrDecode)
 			continue
 		}
 
-		validID := false
-		for _, id := range allReqIDs {
-			if id == result.RequestID {
-				validID = true
+		if result.PDUType == Report && x.Version == Version3 && packetOut.SecurityParameters != nil {
+			if packetOut.SecurityParameters.AuthoritativeEngineID == "" {
+				// the expected response to engine discovery
+				return result, nil
 			}
+			x.secMu.Lock()
+			resync := session && !resent && sp.resyncFromReport(result)
+			x.secMu.Unlock()
+			if resync {
+				// the engine ID or time was out of date, resend once
+				// without counting it as a retry
+				resent = true
+				retries--
+				err = reportError(result)
+				continue
+			}
+			// Don't retry - the agent has rejected the request
+			err = reportError(result)
+			break
 		}
-		if !validID {
-			err = fmt.Errorf("Out of order response")
+		if x.Version == Version3 && result.RequestID != reqID {
+			// matched on msgID, but the scopedPDU answers another request
+			err = fmt.Errorf("%w: request id %d, expected %d", ErrOutOfOrder, result.RequestID, reqID)
 			continue
 		}
+		if session && result.MsgFlags&AuthNoPriv != 0 {
+			x.secMu.Lock()
+			sp.updateEngineTime(result.SecurityParameters)
+			x.secMu.Unlock()
+		}
 
 		// Success!
 		return result, nil
@@ -216,97 +401,596 @@ func (x *GoSNMP) send(pdus []SnmpPDU, packetOut *SnmpPacket) (result *SnmpPacket
 	return nil, err
 }
 
-// Get sends an SNMP GET request
+// muxResponse is a received message (or the error decoding it), routed to
+// the request awaiting it
+type muxResponse struct {
+	packet *SnmpPacket
+	err    error
+}
+
+// responseReader reads messages from transport until it fails, when done
+// is closed with err set. conn is the Conn wrapped by transport, if any.
+type responseReader struct {
+	conn      net.Conn
+	transport Transport
+	done      chan struct{}
+	once      sync.Once
+	err       error
+}
+
+// fail stops r with err. A failed stream (TCP) connection is closed, and
+// re-established by the next request.
+func (r *responseReader) fail(err error) {
+	r.once.Do(func() {
+		r.err = err
+		if r.conn != nil && isStream(r.conn) {
+			r.transport.Close()
+		}
+		close(r.done)
+	})
+}
+
+// failed reports whether r has stopped
+func (r *responseReader) failed() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// current reports whether r reads from the Transport (or Conn) in use
+func (x *GoSNMP) current(r *responseReader) bool {
+	if x.Transport != nil {
+		return r.transport == x.Transport
+	}
+	return r.conn == x.Conn
+}
+
+// await registers a request as awaiting a response on ch, and returns the
+// reader of the Transport (or Conn), starting one if necessary. A broken
+// TCP connection is re-established first.
+func (x *GoSNMP) await(ctx context.Context, id uint32, ch chan muxResponse) (*responseReader, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if r := x.reader; r != nil && x.current(r) && r.failed() {
+		if r.conn != nil && isStream(r.conn) && strings.HasPrefix(x.Network, "tcp") {
+			conn, err := x.dial(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("Error re-establishing connection to host: %s", err.Error())
+			}
+			x.logPrintf("Reconnected to %s after: %v", conn.RemoteAddr(), r.err)
+			x.Conn = conn
+		}
+		x.reader = nil
+	}
+	if x.reader == nil || !x.current(x.reader) {
+		if x.Transport != nil {
+			x.reader = &responseReader{transport: x.Transport}
+		} else {
+			x.reader = &responseReader{conn: x.Conn, transport: newConnTransport(x.Conn)}
+		}
+		x.reader.done = make(chan struct{})
+		go x.readResponses(x.reader)
+	}
+	if x.pending == nil {
+		x.pending = make(map[uint32]chan muxResponse)
+	}
+	x.pending[id] = ch
+	return x.reader, nil
+}
+
+// forget deregisters requests once their responses are no longer awaited
+func (x *GoSNMP) forget(ids []uint32) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, id := range ids {
+		delete(x.pending, id)
+	}
+}
+
+// readResponses passes each message read by r to the request awaiting it.
+// After a read error the requests awaiting responses are retried, with a
+// new reader.
+func (x *GoSNMP) readResponses(r *responseReader) {
+	for {
+		msg, err := r.transport.Receive(time.Time{})
+		if errors.Is(err, ErrTruncated) {
+			x.dispatchTruncated(msg, err)
+			continue
+		}
+		if err != nil {
+			r.fail(err)
+			return
+		}
+		x.dispatch(msg)
+	}
+}
+
+// dispatch decodes a message and routes it by request ID, or for SNMPv3 by
+// msgID - so that SNMPv3 messages failing authentication or decryption are
+// reported to their request. Other messages are discarded.
+func (x *GoSNMP) dispatch(msg []byte) {
+	defer func() {
+		if e := recover(); e != nil {
+			x.logPrintf("Discarding response: %v", e)
+		}
+	}()
+
+	x.secMu.Lock()
+	result, err := x.unmarshalResponse(msg)
+	x.secMu.Unlock()
+	var id uint32
+	switch {
+	case err == nil && result.Version == Version3:
+		id = result.MsgID
+	case err == nil:
+		id = result.RequestID
+	default:
+		header := new(SnmpPacket)
+		if _, herr := x.unmarshalHeader(msg, header); herr != nil || header.Version != Version3 {
+			x.logPrintf("Discarding response: %v", err)
+			return
+		}
+		id = header.MsgID
+	}
+
+	x.deliver(id, muxResponse{result, err})
+}
+
+// dispatchTruncated reports a truncated message to its request
+func (x *GoSNMP) dispatchTruncated(msg []byte, err error) {
+	id, idErr := x.truncatedID(msg)
+	if idErr != nil {
+		x.logPrintf("Discarding response: %v (%v)", err, idErr)
+		return
+	}
+	x.deliver(id, muxResponse{err: fmt.Errorf("%w: %w", ErrDecode, err)})
+}
+
+// truncatedID returns the request ID (msgID for SNMPv3) from the start of a
+// truncated message
+func (x *GoSNMP) truncatedID(msg []byte) (id uint32, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%w: %v", ErrDecode, e)
+		}
+	}()
+
+	// make the message length match what was received, to parse the header
+	fixed := append([]byte(nil), msg...)
+	_, cursor := parseLength(fixed)
+	length := len(fixed) - cursor
+	for i := cursor - 1; i >= 2; i-- {
+		fixed[i] = byte(length)
+		length >>= 8
+	}
+	header := new(SnmpPacket)
+	if cursor, err = x.unmarshalHeader(fixed, header); err != nil {
+		return 0, err
+	}
+	if header.Version == Version3 {
+		return header.MsgID, nil
+	}
+
+	// the request ID follows the PDU type and length
+	_, count := parseLength(fixed[cursor:])
+	rawRequestID, _, err := parseRawField(x.Logger, fixed[cursor+count:], "request id")
+	if err != nil {
+		return 0, err
+	}
+	requestID, ok := rawRequestID.(int)
+	if !ok {
+		return 0, fmt.Errorf("%w: no request id", ErrDecode)
+	}
+	return uint32(requestID), nil
+}
+
+// deliver passes a response to the request awaiting it
+func (x *GoSNMP) deliver(id uint32, response muxResponse) {
+	x.mu.Lock()
+	ch, ok := x.pending[id]
+	x.mu.Unlock()
+	if !ok {
+		x.logPrintf("Discarding response to unknown request %d", id)
+		return
+	}
+	select {
+	case ch <- response:
+	default:
+		// a response to an earlier try has already been received
+	}
+}
+
+// unmarshalResponse decodes a received message. SNMPv3 messages are
+// authenticated and decrypted before their scopedPDU is parsed.
+func (x *GoSNMP) unmarshalResponse(resp []byte) (result *SnmpPacket, err error) {
+	// a wrong privacy key produces garbage rather than an error, so failing
+	// to parse a decrypted scopedPDU is reported as a decryption error
+	decrypted := false
+	defer func() {
+		if e := recover(); e != nil {
+			if decrypted {
+				err = fmt.Errorf("%w: %v", ErrDecryption, e)
+			} else {
+				err = fmt.Errorf("%w: %v", ErrDecode, e)
+			}
+			result = nil
+		}
+	}()
+
+	result = new(SnmpPacket)
+	cursor, err := x.unmarshalHeader(resp, result)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDecode, err.Error())
+	}
+	if result.Version == Version3 {
+		if err = x.checkSecurity(resp, result); err != nil {
+			return nil, err
+		}
+		if result.MsgFlags&AuthPriv == AuthPriv {
+			// parse the decrypted scopedPDU in place of the message
+			if resp, err = x.decryptScopedPDU(resp, cursor, result); err != nil {
+				return nil, err
+			}
+			cursor = 0
+			decrypted = true
+		}
+	}
+	result, err = x.unmarshalPayload(resp, cursor, result)
+	if err != nil && decrypted {
+		return nil, fmt.Errorf("%w: %s", ErrDecryption, err.Error())
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDecode, err.Error())
+	}
+	// agents send usmStats reports without authentication, anything else
+	// must be at the requested security level
+	if result.Version == Version3 && result.PDUType != Report &&
+		result.MsgFlags&AuthNoPriv < x.MsgFlags&AuthNoPriv {
+		return nil, fmt.Errorf("%w: received an unauthenticated response", ErrAuthentication)
+	}
+	return result, nil
+}
+
+// mkSnmpPacket builds the SnmpPacket for an outgoing request, with the
+// security parameters of the session
+func (x *GoSNMP) mkSnmpPacket(pdutype PDUType, nonRepeaters uint32, maxRepetitions uint32) *SnmpPacket {
+	x.secMu.Lock()
+	sp := x.session()
+	x.secMu.Unlock()
+	return &SnmpPacket{
+		Version:            x.Version,
+		Community:          x.Community,
+		MsgFlags:           x.MsgFlags,
+		SecurityModel:      x.SecurityModel,
+		SecurityParameters: sp,
+		ContextEngineID:    x.ContextEngineID,
+		ContextName:        x.ContextName,
+		Error:              0,
+		ErrorIndex:         0,
+		PDUType:            pdutype,
+		NonRepeaters:       nonRepeaters,
+		MaxRepetitions:     maxRepetitions,
+	}
+}
+
+// logPrint and logPrintf write debugging output to Logger, if it is set
+func (x *GoSNMP) logPrint(v ...interface{}) {
+	if x.Logger != nil {
+		x.Logger.Print(v...)
+	}
+}
+
+func (x *GoSNMP) logPrintf(format string, v ...interface{}) {
+	if x.Logger != nil {
+		x.Logger.Printf(format, v...)
+	}
+}
+
+// checkOidCount enforces the MaxOids limit on the number of varbinds in a
+// request
+func (x *GoSNMP) checkOidCount(count int) error {
+	limit := x.MaxOids
+	if limit <= 0 {
+		limit = maxOids
+	}
+	if count > limit {
+		return fmt.Errorf("oid count (%d) is greater than maxOids (%d)",
+			count, limit)
+	}
+	return nil
+}
+
+// Get sends an SNMP GET request. Any number of oids may be requested: they
+// are split into batches of at most MaxOids, and MaxRequestSize bytes, and
+// the responses merged in order into one SnmpPacket. If the agent responds
+// with an error-status, the response is returned with a *RequestError.
 func (x *GoSNMP) Get(oids []string) (result *SnmpPacket, err error) {
-	oidCount := len(oids)
-	if oidCount > maxOids {
-		return nil, fmt.Errorf("oid count (%d) is greater than maxOids (%d)",
-			oidCount, maxOids)
+	return x.GetContext(context.Background(), oids)
+}
+
+// GetContext is Get, stopping with ctx.Err() once ctx is done
+func (x *GoSNMP) GetContext(ctx context.Context, oids []string) (result *SnmpPacket, err error) {
+	result, err = x.getBatched(ctx, GetRequest, oids)
+	return checkStatus(result, err, nullPDUs(oids))
+}
+
+// GetOIDs is Get for oids kept as OIDs, eg from SnmpPDU.OID
+func (x *GoSNMP) GetOIDs(oids []OID) (result *SnmpPacket, err error) {
+	return x.GetContext(context.Background(), oidStrings(oids))
+}
+
+// GetOIDsContext is GetOIDs, stopping with ctx.Err() once ctx is done
+func (x *GoSNMP) GetOIDsContext(ctx context.Context, oids []OID) (result *SnmpPacket, err error) {
+	return x.GetContext(ctx, oidStrings(oids))
+}
+
+// getBatched splits oids into batches, and sends a request for each
+func (x *GoSNMP) getBatched(ctx context.Context, pduType PDUType, oids []string) (result *SnmpPacket, err error) {
+	batches, err := x.batches(oids)
+	if err != nil {
+		return nil, err
 	}
-	// convert oids slice to pdu slice
-	var pdus []SnmpPDU
-	for _, oid := range oids {
-		pdus = append(pdus, SnmpPDU{oid, Null, nil})
+	if len(batches) == 1 {
+		return x.get(ctx, pduType, oids)
 	}
-	// build up SnmpPacket
-	packetOut := &SnmpPacket{
-		Community:  x.Community,
-		Error:      0,
-		ErrorIndex: 0,
-		PDUType:    GetRequest,
-		Version:    x.Version,
+	x.logPrintf("Splitting %d oids into %d requests", len(oids), len(batches))
+	return x.getMerged(ctx, pduType, batches)
+}
+
+// get sends one GET or GETNEXT request, splitting it if the response is
+// tooBig
+func (x *GoSNMP) get(ctx context.Context, pduType PDUType, oids []string) (result *SnmpPacket, err error) {
+	packetOut := x.mkSnmpPacket(pduType, 0, 0)
+	result, err = x.sendContext(ctx, nullPDUs(oids), packetOut)
+	if err == nil && result.Error == TooBig && len(oids) > 1 {
+		// the response won't fit in a message - split the request
+		x.logPrintf("Response too big, splitting %d oids", len(oids))
+		half := len(oids) / 2
+		return x.getMerged(ctx, pduType, [][]string{oids[:half], oids[half:]})
 	}
-	return x.send(pdus, packetOut)
+	if err == nil && x.Negotiate && x.Version == Version1 && result.Error == NoSuchName &&
+		result.ErrorIndex > 0 && int(result.ErrorIndex) <= len(oids) {
+		return x.getV1Exception(ctx, pduType, oids, int(result.ErrorIndex)-1, result)
+	}
+	return result, err
 }
 
-// Set sends an SNMP SET request
-func (x *GoSNMP) Set(pdus []SnmpPDU) (result *SnmpPacket, err error) {
-	if len(pdus) != 1 {
-		return nil, fmt.Errorf("gosnmp currently only supports SNMP SETs for one oid")
+// getV1Exception translates the noSuchName error of an SNMPv1 agent into an
+// SNMPv2 exception value for the failing varbind - noSuchObject for GET,
+// endOfMibView for GETNEXT - and requests the other oids again, as a proxy
+// does - RFC 3584 4.2.1
+func (x *GoSNMP) getV1Exception(ctx context.Context, pduType PDUType, oids []string, failed int, result *SnmpPacket) (*SnmpPacket, error) {
+	exception := SnmpPDU{Name: oids[failed], Type: NoSuchObject, Value: nil}
+	if pduType == GetNextRequest {
+		exception.Type = EndOfMibView
 	}
-	if pdus[0].Type != Integer {
-		return nil, fmt.Errorf("gosnmp currently only supports SNMP SETs for Integers")
+	rest := make([]string, 0, len(oids)-1)
+	rest = append(rest, oids[:failed]...)
+	rest = append(rest, oids[failed+1:]...)
+
+	response := *result
+	response.Error, response.ErrorIndex, response.Variables = NoError, 0, nil
+	if len(rest) > 0 {
+		next, err := x.get(ctx, pduType, rest)
+		if err != nil {
+			return nil, err
+		}
+		if next.Error != NoError || len(next.Variables) != len(rest) {
+			if next.ErrorIndex > uint32(failed) {
+				// ErrorIndex refers to the varbinds of the whole request
+				next.ErrorIndex++
+			}
+			return next, nil
+		}
+		response = *next
 	}
-	// build up SnmpPacket
-	packetOut := &SnmpPacket{
-		Community:  x.Community,
-		Error:      0,
-		ErrorIndex: 0,
-		PDUType:    SetRequest,
-		Version:    x.Version,
+	variables := make([]SnmpPDU, 0, len(oids))
+	variables = append(variables, response.Variables[:failed]...)
+	variables = append(variables, exception)
+	response.Variables = append(variables, response.Variables[failed:]...)
+	return &response, nil
+}
+
+// nullPDUs returns the varbinds requesting oids, with Null values
+func nullPDUs(oids []string) []SnmpPDU {
+	pdus := make([]SnmpPDU, len(oids))
+	for i, oid := range oids {
+		pdus[i] = SnmpPDU{Name: oid, Type: Null, Value: nil}
 	}
-	return x.send(pdus, packetOut)
+	return pdus
 }
 
-// GetNext sends an SNMP GETNEXT request
-func (x *GoSNMP) GetNext(oids []string) (result *SnmpPacket, err error) {
-	oidCount := len(oids)
-	if oidCount > maxOids {
-		return nil, fmt.Errorf("oid count (%d) is greater than maxOids (%d)",
-			oidCount, maxOids)
+// batches splits oids into batches of at most MaxOids oids, whose varbinds
+// total at most MaxRequestSize bytes less a header allowance
+func (x *GoSNMP) batches(oids []string) ([][]string, error) {
+	limit := x.MaxOids
+	if limit <= 0 {
+		limit = maxOids
+	}
+	size := x.MaxRequestSize
+	if size <= 0 {
+		size = maxRequestSize
+	}
+	size -= x.headerSize()
+
+	var batches [][]string
+	start, total := 0, 0
+	for i, oid := range oids {
+		vb, err := marshalVarbind(&SnmpPDU{Name: oid, Type: Null, Value: nil})
+		if err != nil {
+			return nil, fmt.Errorf("Unable to marshal oid %s: %s", oid, err)
+		}
+		if i > start && (i-start == limit || total+len(vb) > size) {
+			batches = append(batches, oids[start:i])
+			start, total = i, 0
+		}
+		total += len(vb)
 	}
+	return append(batches, oids[start:]), nil
+}
+
+// headerSize estimates the encoded size of a request less its varbinds
+func (x *GoSNMP) headerSize() int {
+	// sequences, version, request ID, error status and index
+	size := 32 + len(x.Community)
+	if x.Version == Version3 {
+		// msgGlobalData, and USM parameters with up to 32 octet engine IDs
+		size += 128 + len(x.ContextName)
+		if sp := x.SecurityParameters; sp != nil {
+			size += len(sp.UserName)
+		}
+	}
+	return size
+}
+
+// errBatchRefused stops the remaining batches of a request once the agent
+// returns an error-status for one of them
+var errBatchRefused = errors.New("Batch refused")
+
+// getMerged sends a request for each batch of oids, with up to Concurrency
+// requests in flight, and merges the responses in order. The first error
+// stops the remaining requests.
+func (x *GoSNMP) getMerged(ctx context.Context, pduType PDUType, batches [][]string) (*SnmpPacket, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	// convert oids slice to pdu slice
-	var pdus []SnmpPDU
-	for _, oid := range oids {
-		pdus = append(pdus, SnmpPDU{oid, Null, nil})
+	concurrency := x.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	slots := make(chan struct{}, concurrency)
+	results := make([]*SnmpPacket, len(batches))
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for i, batch := range batches {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, batch []string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			result, err := x.get(ctx, pduType, batch)
+			if err == nil && result.Error != NoError {
+				// the agent refused the batch, so the merged request fails
+				err = errBatchRefused
+			}
+			if err != nil {
+				once.Do(func() { firstErr = err })
+				cancel()
+			}
+			results[i] = result
+		}(i, batch)
+	}
+	wg.Wait()
+	if firstErr != nil && firstErr != errBatchRefused {
+		return nil, firstErr
 	}
 
-	// Marshal and send the packet
-	packetOut := &SnmpPacket{
-		Community:  x.Community,
-		Error:      0,
-		ErrorIndex: 0,
-		PDUType:    GetNextRequest,
-		Version:    x.Version,
+	var merged *SnmpPacket
+	offset := 0
+	for i, result := range results {
+		if result == nil {
+			// stopped by a refused batch
+			offset += len(batches[i])
+			continue
+		}
+		if merged == nil {
+			m := *result
+			m.Variables = nil
+			merged = &m
+		}
+		if result.Error != NoError && merged.Error == NoError {
+			merged.Error = result.Error
+			merged.ErrorIndex = result.ErrorIndex
+			if result.ErrorIndex > 0 {
+				// ErrorIndex refers to the varbinds of the whole request
+				merged.ErrorIndex += uint32(offset)
+			}
+		}
+		merged.Variables = append(merged.Variables, result.Variables...)
+		offset += len(batches[i])
 	}
+	return merged, nil
+}
 
-	return x.send(pdus, packetOut)
+// Set sends an SNMP SET request. The Value of each SnmpPDU is encoded
+// according to its Type, eg an OctetString from a string or []byte.
+//
+// All the varbinds are sent in one PDU, so the agent applies them atomically:
+// either every value is set, or none are. If the agent rejects the request
+// the response is returned along with a *RequestError naming the failing
+// varbind, from the response's Error (error-status) and ErrorIndex.
+func (x *GoSNMP) Set(pdus []SnmpPDU) (result *SnmpPacket, err error) {
+	return x.SetContext(context.Background(), pdus)
 }
 
-// GetBulk sends an SNMP GETBULK request
-func (x *GoSNMP) GetBulk(oids []string, nonRepeaters uint8, maxRepetitions uint8) (result *SnmpPacket, err error) {
-	oidCount := len(oids)
-	if oidCount > maxOids {
-		return nil, fmt.Errorf("oid count (%d) is greater than maxOids (%d)",
-			oidCount, maxOids)
+// SetContext is Set, stopping with ctx.Err() once ctx is done
+func (x *GoSNMP) SetContext(ctx context.Context, pdus []SnmpPDU) (result *SnmpPacket, err error) {
+	if len(pdus) == 0 {
+		return nil, fmt.Errorf("Set requires at least one varbind")
+	}
+	if err := x.checkOidCount(len(pdus)); err != nil {
+		return nil, err
 	}
+	// build up SnmpPacket
+	packetOut := x.mkSnmpPacket(SetRequest, 0, 0)
+	result, err = x.sendContext(ctx, pdus, packetOut)
+	return checkStatus(result, err, pdus)
+}
+
+// GetNext sends an SNMP GETNEXT request. As for Get, any number of oids may
+// be requested.
+func (x *GoSNMP) GetNext(oids []string) (result *SnmpPacket, err error) {
+	return x.GetNextContext(context.Background(), oids)
+}
+
+// GetNextContext is GetNext, stopping with ctx.Err() once ctx is done
+func (x *GoSNMP) GetNextContext(ctx context.Context, oids []string) (result *SnmpPacket, err error) {
+	result, err = x.getBatched(ctx, GetNextRequest, oids)
+	return checkStatus(result, err, nullPDUs(oids))
+}
 
-	// convert oids slice to pdu slice
-	var pdus []SnmpPDU
-	for _, oid := range oids {
-		pdus = append(pdus, SnmpPDU{oid, Null, nil})
+// GetNextOIDs is GetNext for oids kept as OIDs, eg from SnmpPDU.OID
+func (x *GoSNMP) GetNextOIDs(oids []OID) (result *SnmpPacket, err error) {
+	return x.GetNextContext(context.Background(), oidStrings(oids))
+}
+
+// GetNextOIDsContext is GetNextOIDs, stopping with ctx.Err() once ctx is done
+func (x *GoSNMP) GetNextOIDsContext(ctx context.Context, oids []OID) (result *SnmpPacket, err error) {
+	return x.GetNextContext(ctx, oidStrings(oids))
+}
+
+// GetBulk sends an SNMP GETBULK request
+func (x *GoSNMP) GetBulk(oids []string, nonRepeaters uint32, maxRepetitions uint32) (result *SnmpPacket, err error) {
+	return x.GetBulkContext(context.Background(), oids, nonRepeaters, maxRepetitions)
+}
+
+// GetBulkContext is GetBulk, stopping with ctx.Err() once ctx is done
+func (x *GoSNMP) GetBulkContext(ctx context.Context, oids []string, nonRepeaters uint32, maxRepetitions uint32) (result *SnmpPacket, err error) {
+	result, _, err = x.getBulk(ctx, oids, nonRepeaters, maxRepetitions)
+	return checkStatus(result, err, nullPDUs(oids))
+}
+
+// getBulk sends a GETBULK request, halving maxRepetitions while the agent
+// responds tooBig. It returns the maxRepetitions of the final request.
+func (x *GoSNMP) getBulk(ctx context.Context, oids []string, nonRepeaters uint32, maxRepetitions uint32) (*SnmpPacket, uint32, error) {
+	if err := x.checkOidCount(len(oids)); err != nil {
+		return nil, maxRepetitions, err
 	}
 
-	// Marshal and send the packet
-	packetOut := &SnmpPacket{
-		Community:      x.Community,
-		PDUType:        GetBulkRequest,
-		Version:        x.Version,
-		NonRepeaters:   nonRepeaters,
-		MaxRepetitions: maxRepetitions,
+	pdus := nullPDUs(oids)
+	for {
+		// Marshal and send the packet
+		packetOut := x.mkSnmpPacket(GetBulkRequest, nonRepeaters, maxRepetitions)
+		result, err := x.sendContext(ctx, pdus, packetOut)
+		if err != nil || result.Error != TooBig || maxRepetitions <= 1 {
+			return result, maxRepetitions, err
+		}
+		maxRepetitions /= 2
+		x.logPrintf("GetBulk response too big, retrying with max-repetitions %d", maxRepetitions)
 	}
-	return x.send(pdus, packetOut)
 }
 
 //
@@ -320,15 +1004,58 @@ type WalkFunc func(dataUnit SnmpPDU) error
 // BulkWalk retrieves a subtree of values using GETBULK. As the tree is
 // walked walkFn is called for each new value. The function immediately returns
 // an error if either there is an underlaying SNMP error (e.g. GetBulk fails),
-// or if walkFn returns an error.
+// or if walkFn returns an error. SNMPv1 has no GETBULK, so for SNMPv1 BulkWalk
+// is a Walk.
 func (x *GoSNMP) BulkWalk(rootOid string, walkFn WalkFunc) error {
-	return x.walk(GetBulkRequest, rootOid, walkFn)
+	return x.walk(context.Background(), GetBulkRequest, rootOid, walkFn)
+}
+
+// BulkWalkContext is BulkWalk, stopping with ctx.Err() once ctx is done
+func (x *GoSNMP) BulkWalkContext(ctx context.Context, rootOid string, walkFn WalkFunc) error {
+	return x.walk(ctx, GetBulkRequest, rootOid, walkFn)
 }
 
 // BulkWalkAll is similar to BulkWalk but returns a filled array of all values
 // rather than using a callback function to stream results.
 func (x *GoSNMP) BulkWalkAll(rootOid string) (results []SnmpPDU, err error) {
-	return x.walkAll(GetBulkRequest, rootOid)
+	return x.walkAll(context.Background(), GetBulkRequest, rootOid)
+}
+
+// BulkWalkAllContext is BulkWalkAll, stopping with ctx.Err() once ctx is done
+func (x *GoSNMP) BulkWalkAllContext(ctx context.Context, rootOid string) (results []SnmpPDU, err error) {
+	return x.walkAll(ctx, GetBulkRequest, rootOid)
+}
+
+// ColumnWalkFunc is the type of the function called for each data unit
+// visited by BulkWalkColumns, with the position in roots of its subtree
+type ColumnWalkFunc func(column int, dataUnit SnmpPDU) error
+
+// BulkWalkColumns retrieves several subtrees at once eg the columns of a
+// table, with each unfinished subtree a repeater of the same GETBULK request.
+// Each subtree is tracked separately, and dropped from later requests once it
+// ends. walkFn is called for each new value, in order within each subtree;
+// values of different subtrees are interleaved. SNMPv1 uses GETNEXT.
+func (x *GoSNMP) BulkWalkColumns(roots []string, walkFn ColumnWalkFunc) error {
+	return x.BulkWalkColumnsContext(context.Background(), roots, walkFn)
+}
+
+// BulkWalkColumnsContext is BulkWalkColumns, stopping with ctx.Err() once ctx
+// is done
+func (x *GoSNMP) BulkWalkColumnsContext(ctx context.Context, roots []string, walkFn ColumnWalkFunc) error {
+	oids := make([]OID, len(roots))
+	for i, root := range roots {
+		if root == "" || root == "." {
+			root = baseOid
+		}
+		oid, err := ParseOID(root)
+		if err != nil {
+			return err
+		}
+		oids[i] = oid
+	}
+	return x.walkColumns(ctx, oids, func(column int, _ OID, dataUnit SnmpPDU) error {
+		return walkFn(column, dataUnit)
+	})
 }
 
 // Walk retrieves a subtree of values using GETNEXT - a request is made for each
@@ -337,13 +1064,23 @@ func (x *GoSNMP) BulkWalkAll(rootOid string) (results []SnmpPDU, err error) {
 // an error if either there is an underlaying SNMP error (e.g. GetNext fails),
 // or if walkFn returns an error.
 func (x *GoSNMP) Walk(rootOid string, walkFn WalkFunc) error {
-	return x.walk(GetNextRequest, rootOid, walkFn)
+	return x.walk(context.Background(), GetNextRequest, rootOid, walkFn)
+}
+
+// WalkContext is Walk, stopping with ctx.Err() once ctx is done
+func (x *GoSNMP) WalkContext(ctx context.Context, rootOid string, walkFn WalkFunc) error {
+	return x.walk(ctx, GetNextRequest, rootOid, walkFn)
 }
 
 // WalkAll is similar to Walk but returns a filled array of all values rather
 // than using a callback function to stream results.
 func (x *GoSNMP) WalkAll(rootOid string) (results []SnmpPDU, err error) {
-	return x.walkAll(GetNextRequest, rootOid)
+	return x.walkAll(context.Background(), GetNextRequest, rootOid)
+}
+
+// WalkAllContext is WalkAll, stopping with ctx.Err() once ctx is done
+func (x *GoSNMP) WalkAllContext(ctx context.Context, rootOid string) (results []SnmpPDU, err error) {
+	return x.walkAll(ctx, GetNextRequest, rootOid)
 }
 
 //
@@ -10,6 +10,7 @@
 package gosnmp_test // force external view
 
 import (
+	"context"
 	"io/ioutil"
 	"log"
 	"net"
@@ -31,6 +32,7 @@ func TestAPIConfigTypes(t *testing.T) {
 	g.Logger = log.New(ioutil.Discard, "", 0)
 	g.MaxRepetitions = 0
 	g.NonRepeaters = 0
+	g.MaxOids = 0
 
 	var c net.Conn
 	c = g.Conn
@@ -91,6 +93,27 @@ func TestAPIWalkAllMethodSignature(t *testing.T) {
 	_ = f
 }
 
+func TestAPIContextMethodSignatures(t *testing.T) {
+	var get func(context.Context, []string) (*gosnmp.SnmpPacket, error)
+	get = gosnmp.Default.GetContext
+	get = gosnmp.Default.GetNextContext
+	_ = get
+
+	var set func(context.Context, []gosnmp.SnmpPDU) (*gosnmp.SnmpPacket, error)
+	set = gosnmp.Default.SetContext
+	_ = set
+
+	var walk func(context.Context, string, gosnmp.WalkFunc) error
+	walk = gosnmp.Default.WalkContext
+	walk = gosnmp.Default.BulkWalkContext
+	_ = walk
+
+	var walkAll func(context.Context, string) ([]gosnmp.SnmpPDU, error)
+	walkAll = gosnmp.Default.WalkAllContext
+	walkAll = gosnmp.Default.BulkWalkAllContext
+	_ = walkAll
+}
+
 func TestAPIWalkFuncSignature(t *testing.T) {
 	var f gosnmp.WalkFunc
 	f = func(du gosnmp.SnmpPDU) (err error) { return }
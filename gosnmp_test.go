@@ -0,0 +1,942 @@
+// Copyright 2012-2014 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testAgent answers each request received on transport with reply, which is
+// given the decoded request and returns the response packet and varbinds
+func testAgent(t *testing.T, transport Transport,
+	reply func(request *SnmpPacket) (*SnmpPacket, []SnmpPDU)) {
+	for {
+		msg, err := transport.Receive(time.Time{})
+		if err != nil {
+			return
+		}
+		request, err := new(GoSNMP).unmarshal(msg)
+		if err != nil {
+			t.Errorf("agent: unable to decode request: %v", err)
+			return
+		}
+
+		response, pdus := reply(request)
+		if response == nil {
+			// dropped
+			continue
+		}
+		msg, err = response.marshalMsg(pdus, response.PDUType, request.RequestID)
+		if err != nil {
+			t.Errorf("agent: unable to marshal reply: %v", err)
+			return
+		}
+		transport.Send(msg)
+	}
+}
+
+// testClient returns a v2c GoSNMP, piped to a testAgent using reply
+func testClient(t *testing.T,
+	reply func(request *SnmpPacket) (*SnmpPacket, []SnmpPDU)) *GoSNMP {
+	client, agent := NewPipeTransport()
+	go testAgent(t, agent, reply)
+
+	return &GoSNMP{
+		Transport: client,
+		Community: "private",
+		Version:   Version2c,
+		Timeout:   time.Duration(2) * time.Second,
+		Retries:   1,
+	}
+}
+
+func TestSetMultipleVarbinds(t *testing.T) {
+	const (
+		rowStatus = ".1.3.6.1.4.1.9999.1.1.4.7"
+		rowName   = ".1.3.6.1.4.1.9999.1.1.2.7"
+		rowAddr   = ".1.3.6.1.4.1.9999.1.1.3.7"
+	)
+
+	x := testClient(t, func(request *SnmpPacket) (*SnmpPacket, []SnmpPDU) {
+		response := &SnmpPacket{
+			Version:   request.Version,
+			Community: request.Community,
+			PDUType:   GetResponse,
+		}
+		// reject a row name that's too long, at its (1-based) position
+		for i, v := range request.Variables {
+			if v.Name == rowName && len(v.Value.(OctetStringValue)) > 8 {
+				response.Error = WrongValue
+				response.ErrorIndex = uint32(i + 1)
+			}
+		}
+		var pdus []SnmpPDU
+		for _, v := range request.Variables {
+			pdus = append(pdus, SnmpPDU{Name: v.Name, Type: v.Type, Value: v.Value})
+		}
+		return response, pdus
+	})
+	defer x.Transport.Close()
+
+	pdus := []SnmpPDU{
+		{Name: rowStatus, Type: Integer, Value: 4}, // createAndGo
+		{Name: rowName, Type: OctetString, Value: "uplink"},
+		{Name: rowAddr, Type: IPAddress, Value: "192.0.2.1"},
+	}
+	result, err := x.Set(pdus)
+	if err != nil {
+		t.Fatalf("Set() err returned: %v", err)
+	}
+	if len(result.Variables) != 3 {
+		t.Fatalf("Set() got %d variables, expected 3", len(result.Variables))
+	}
+	for i, v := range result.Variables {
+		if v.Name != pdus[i].Name || fmt.Sprint(v.Value) != fmt.Sprint(pdus[i].Value) {
+			t.Errorf("Set() variable %d got %s %v, expected %s %v",
+				i, v.Name, v.Value, pdus[i].Name, pdus[i].Value)
+		}
+	}
+
+	pdus[1].Value = "a much too long name"
+	result, err = x.Set(pdus)
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) {
+		t.Fatalf("Set() got err %v, expected a *RequestError for a wrongValue response", err)
+	}
+	if reqErr.Status != WrongValue || reqErr.Index != 2 || reqErr.Varbind.Name != rowName {
+		t.Errorf("Set() got %v, expected wrongValue at varbind 2", reqErr)
+	}
+	if result == nil || result.Error != WrongValue || result.ErrorIndex != 2 {
+		t.Errorf("Set() got response %+v, expected error-status 10 at index 2", result)
+	}
+	if !strings.Contains(err.Error(), rowName) {
+		t.Errorf("Set() error %q doesn't name the failing varbind %s", err, rowName)
+	}
+}
+
+func TestSetMaxOids(t *testing.T) {
+	x := &GoSNMP{MaxOids: 2}
+	pdus := []SnmpPDU{
+		{Name: ".1.3.6.1.2.1.1.4.0", Type: OctetString, Value: "a"},
+		{Name: ".1.3.6.1.2.1.1.5.0", Type: OctetString, Value: "b"},
+		{Name: ".1.3.6.1.2.1.1.6.0", Type: OctetString, Value: "c"},
+	}
+	if _, err := x.Set(pdus); err == nil {
+		t.Errorf("Set() of 3 varbinds with MaxOids 2 expected an error")
+	}
+	if _, err := x.Set(nil); err == nil {
+		t.Errorf("Set() of no varbinds expected an error")
+	}
+}
+
+// silentClient returns a GoSNMP connected to a port that never answers
+func silentClient(t *testing.T) (*GoSNMP, *net.UDPConn) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	x := &GoSNMP{
+		Target:    "127.0.0.1",
+		Port:      uint16(conn.LocalAddr().(*net.UDPAddr).Port),
+		Community: "public",
+		Version:   Version2c,
+		Timeout:   time.Duration(10) * time.Second,
+		Retries:   2,
+	}
+	if err = x.Connect(); err != nil {
+		t.Fatalf("Connect() err returned: %v", err)
+	}
+	return x, conn
+}
+
+func TestGetContextCancel(t *testing.T) {
+	x, conn := silentClient(t)
+	defer conn.Close()
+	defer x.Conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := x.GetContext(ctx, []string{".1.3.6.1.2.1.1.1.0"})
+	if err != context.Canceled {
+		t.Errorf("GetContext() got err %v, expected %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("GetContext() took %s to return after cancel", elapsed)
+	}
+
+	// a cancelled context isn't sent at all
+	if _, err = x.GetContext(ctx, []string{".1.3.6.1.2.1.1.1.0"}); err != context.Canceled {
+		t.Errorf("GetContext() with a cancelled context got err %v", err)
+	}
+}
+
+func TestGetContextDeadline(t *testing.T) {
+	x, conn := silentClient(t)
+	defer conn.Close()
+	defer x.Conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := x.GetContext(ctx, []string{".1.3.6.1.2.1.1.1.0"})
+	if err != context.DeadlineExceeded {
+		t.Errorf("GetContext() got err %v, expected %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("GetContext() took %s, expected the context's deadline", elapsed)
+	}
+}
+
+func TestBulkWalkContextCancel(t *testing.T) {
+	// an endless table
+	x := testClient(t, func(request *SnmpPacket) (*SnmpPacket, []SnmpPDU) {
+		response := &SnmpPacket{Version: request.Version, Community: request.Community, PDUType: GetResponse}
+		var index int
+		fmt.Sscanf(strings.TrimPrefix(request.Variables[0].Name, ".1.3.6.1.2.1.2.2.1.1."), "%d", &index)
+		var pdus []SnmpPDU
+		for i := 1; i <= int(request.MaxRepetitions); i++ {
+			pdus = append(pdus, SnmpPDU{Name: fmt.Sprintf(".1.3.6.1.2.1.2.2.1.1.%d", index+i), Type: Integer, Value: index + i})
+		}
+		return response, pdus
+	})
+	defer x.Transport.Close()
+	x.MaxRepetitions = 10
+
+	ctx, cancel := context.WithCancel(context.Background())
+	seen := 0
+	err := x.BulkWalkContext(ctx, ".1.3.6.1.2.1.2.2.1.1", func(pdu SnmpPDU) error {
+		if seen++; seen == 25 {
+			cancel()
+		}
+		return nil
+	})
+	if err != context.Canceled {
+		t.Errorf("BulkWalkContext() got err %v, expected %v", err, context.Canceled)
+	}
+	if seen != 30 {
+		t.Errorf("BulkWalkContext() walked %d values, expected to stop after the third request", seen)
+	}
+}
+
+// -- Concurrent requests ------------------------------------------------------
+
+// echoIndex answers each request with the last arc of each oid as its value
+func echoIndex(request *SnmpPacket) (*SnmpPacket, []SnmpPDU) {
+	response := &SnmpPacket{Version: request.Version, Community: request.Community, PDUType: GetResponse}
+	var pdus []SnmpPDU
+	for _, v := range request.Variables {
+		var index int
+		fmt.Sscanf(v.Name[strings.LastIndex(v.Name, ".")+1:], "%d", &index)
+		pdus = append(pdus, SnmpPDU{Name: v.Name, Type: Integer, Value: index})
+	}
+	return response, pdus
+}
+
+func TestConcurrentGets(t *testing.T) {
+	x := testClient(t, echoIndex)
+	defer x.Transport.Close()
+
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		go func(i int) {
+			oid := fmt.Sprintf(".1.3.6.1.2.1.2.2.1.2.%d", i)
+			result, err := x.Get([]string{oid})
+			switch {
+			case err != nil:
+				errs <- err
+			case result.Variables[0].Name != oid || result.Variables[0].Value != i:
+				errs <- fmt.Errorf("Get(%s) got %s %v", oid, result.Variables[0].Name, result.Variables[0].Value)
+			default:
+				errs <- nil
+			}
+		}(i)
+	}
+	for i := 0; i < 50; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// responses are matched to requests by request ID, whatever their order
+func TestOutOfOrderResponses(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer conn.Close()
+	go func() {
+		// answer requests in pairs, the second first
+		buf := make([]byte, rxBufSize)
+		var replies [][]byte
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			request, err := new(GoSNMP).unmarshal(buf[:n])
+			if err != nil {
+				t.Errorf("agent: unable to decode request: %v", err)
+				return
+			}
+			response, pdus := echoIndex(request)
+			msg, err := response.marshalMsg(pdus, response.PDUType, request.RequestID)
+			if err != nil {
+				t.Errorf("agent: unable to marshal reply: %v", err)
+				return
+			}
+			if replies = append(replies, msg); len(replies) == 2 {
+				conn.WriteToUDP(replies[1], addr)
+				conn.WriteToUDP(replies[0], addr)
+				replies = nil
+			}
+		}
+	}()
+
+	x := &GoSNMP{
+		Target:    "127.0.0.1",
+		Port:      uint16(conn.LocalAddr().(*net.UDPAddr).Port),
+		Community: "public",
+		Version:   Version2c,
+		Timeout:   time.Duration(2) * time.Second,
+		Retries:   0,
+	}
+	if err = x.Connect(); err != nil {
+		t.Fatalf("Connect() err returned: %v", err)
+	}
+	defer x.Conn.Close()
+
+	results := make(chan *SnmpPacket, 2)
+	for _, oid := range []string{".1.3.6.1.2.1.1.1", ".1.3.6.1.2.1.1.2"} {
+		go func(oid string) {
+			result, err := x.Get([]string{oid})
+			if err != nil {
+				t.Errorf("Get(%s) err returned: %v", oid, err)
+			} else if result.Variables[0].Name != oid {
+				t.Errorf("Get(%s) got the response for %s", oid, result.Variables[0].Name)
+			}
+			results <- result
+		}(oid)
+	}
+	<-results
+	<-results
+}
+
+// -- TCP ----------------------------------------------------------------------
+
+// tcpTestAgent answers requests on each connection accepted by l using
+// reply, closing the connection after closeAfter responses (if non-zero)
+func tcpTestAgent(t *testing.T, l net.Listener, closeAfter int,
+	reply func(request *SnmpPacket) (*SnmpPacket, []SnmpPDU)) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			for answered := 0; closeAfter == 0 || answered < closeAfter; answered++ {
+				msg, err := readMessage(r)
+				if err != nil {
+					return
+				}
+				request, err := new(GoSNMP).unmarshal(msg)
+				if err != nil {
+					t.Errorf("agent: unable to decode request: %v", err)
+					return
+				}
+				response, pdus := reply(request)
+				out, err := response.marshalMsg(pdus, response.PDUType, request.RequestID)
+				if err != nil {
+					t.Errorf("agent: unable to marshal reply: %v", err)
+					return
+				}
+				// write the message in two parts, to exercise the framing
+				conn.Write(out[:3])
+				conn.Write(out[3:])
+			}
+		}()
+	}
+}
+
+// tcpClient returns a GoSNMP connected over TCP to a tcpTestAgent
+func tcpClient(t *testing.T, closeAfter int,
+	reply func(request *SnmpPacket) (*SnmpPacket, []SnmpPDU)) (*GoSNMP, net.Listener) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	go tcpTestAgent(t, l, closeAfter, reply)
+
+	x := &GoSNMP{
+		Target:    "127.0.0.1",
+		Port:      uint16(l.Addr().(*net.TCPAddr).Port),
+		Network:   "tcp",
+		Community: "public",
+		Version:   Version2c,
+		Timeout:   time.Duration(2) * time.Second,
+		Retries:   1,
+	}
+	if err = x.Connect(); err != nil {
+		t.Fatalf("Connect() err returned: %v", err)
+	}
+	return x, l
+}
+
+func TestTCPBulkWalk(t *testing.T) {
+	// a table too large for a UDP datagram
+	const rows = 2000
+	value := strings.Repeat("x", 100)
+	x, l := tcpClient(t, 0, func(request *SnmpPacket) (*SnmpPacket, []SnmpPDU) {
+		response := &SnmpPacket{Version: request.Version, Community: request.Community, PDUType: GetResponse}
+		var index int
+		fmt.Sscanf(strings.TrimPrefix(request.Variables[0].Name, ".1.3.6.1.2.1.2.2.1.2."), "%d", &index)
+		var pdus []SnmpPDU
+		for i := index + 1; i <= index+int(request.MaxRepetitions); i++ {
+			if i > rows {
+				pdus = append(pdus, SnmpPDU{Name: ".1.3.6.1.2.1.2.2.1.3.1", Type: Integer, Value: 6})
+				break
+			}
+			pdus = append(pdus, SnmpPDU{Name: fmt.Sprintf(".1.3.6.1.2.1.2.2.1.2.%d", i), Type: OctetString, Value: value})
+		}
+		return response, pdus
+	})
+	defer l.Close()
+	defer x.Conn.Close()
+	x.MaxRepetitions = 1000
+
+	results, err := x.BulkWalkAll(".1.3.6.1.2.1.2.2.1.2")
+	if err != nil {
+		t.Fatalf("BulkWalkAll() err returned: %v", err)
+	}
+	if len(results) != rows {
+		t.Errorf("BulkWalkAll() got %d rows, expected %d", len(results), rows)
+	}
+}
+
+func TestTCPReconnect(t *testing.T) {
+	// the agent drops each connection after one response
+	x, l := tcpClient(t, 1, echoIndex)
+	defer l.Close()
+	defer func() { x.Conn.Close() }()
+
+	for i := 1; i <= 3; i++ {
+		oid := fmt.Sprintf(".1.3.6.1.2.1.2.2.1.2.%d", i)
+		result, err := x.Get([]string{oid})
+		if err != nil {
+			t.Fatalf("Get() %d err returned: %v", i, err)
+		}
+		if result.Variables[0].Value != i {
+			t.Errorf("Get() %d got %v", i, result.Variables[0].Value)
+		}
+	}
+}
+
+// -- tooBig -------------------------------------------------------------------
+
+func TestGetTooBig(t *testing.T) {
+	// the agent can't fit more than two varbinds in a response
+	var requests int32
+	x := testClient(t, func(request *SnmpPacket) (*SnmpPacket, []SnmpPDU) {
+		atomic.AddInt32(&requests, 1)
+		if len(request.Variables) > 2 {
+			return &SnmpPacket{Version: request.Version, Community: request.Community,
+				PDUType: GetResponse, Error: TooBig}, []SnmpPDU{}
+		}
+		return echoIndex(request)
+	})
+	defer x.Transport.Close()
+
+	var oids []string
+	for i := 1; i <= 7; i++ {
+		oids = append(oids, fmt.Sprintf(".1.3.6.1.2.1.2.2.1.2.%d", i))
+	}
+	result, err := x.Get(oids)
+	if err != nil {
+		t.Fatalf("Get() err returned: %v", err)
+	}
+	if result.Error != NoError || len(result.Variables) != len(oids) {
+		t.Fatalf("Get() got error-status %d and %d variables", result.Error, len(result.Variables))
+	}
+	for i, v := range result.Variables {
+		if v.Name != oids[i] || v.Value != i+1 {
+			t.Errorf("Get() variable %d got %s %v", i, v.Name, v.Value)
+		}
+	}
+	// 7 -> 3+4 -> 3: 1+2, 4: 2+2
+	if requests != 7 {
+		t.Errorf("Get() sent %d requests, expected 7", requests)
+	}
+}
+
+var testsGetBatches = []struct {
+	maxOids        int
+	maxRequestSize int
+	concurrency    int
+	requests       int32
+}{
+	{0, 0, 0, 3},         // 150 oids: 60 + 60 + 30
+	{25, 0, 1, 6},        // 6 x 25
+	{25, 0, 4, 6},        // in flight concurrently
+	{0, 600, 2, 5},       // 16 or 17 byte varbinds, about 35 to a 600 byte request
+	{1000, 100000, 3, 1}, // one request
+}
+
+func TestGetBatches(t *testing.T) {
+	var oids []string
+	for i := 1; i <= 150; i++ {
+		oids = append(oids, fmt.Sprintf(".1.3.6.1.2.1.2.2.1.10.%d", i))
+	}
+	for i, test := range testsGetBatches {
+		var requests int32
+		x := testClient(t, func(request *SnmpPacket) (*SnmpPacket, []SnmpPDU) {
+			atomic.AddInt32(&requests, 1)
+			return echoIndex(request)
+		})
+		x.MaxOids = test.maxOids
+		x.MaxRequestSize = test.maxRequestSize
+		x.Concurrency = test.concurrency
+
+		for _, get := range []func([]string) (*SnmpPacket, error){x.Get, x.GetNext} {
+			atomic.StoreInt32(&requests, 0)
+			result, err := get(oids)
+			if err != nil {
+				t.Fatalf("#%d: Get() err returned: %v", i, err)
+			}
+			if len(result.Variables) != len(oids) {
+				t.Fatalf("#%d: Get() got %d variables, expected %d", i, len(result.Variables), len(oids))
+			}
+			for j, v := range result.Variables {
+				if v.Name != oids[j] || v.Value != j+1 {
+					t.Errorf("#%d: Get() variable %d got %s %v", i, j, v.Name, v.Value)
+				}
+			}
+			if requests != test.requests {
+				t.Errorf("#%d: Get() sent %d requests, expected %d", i, requests, test.requests)
+			}
+		}
+		x.Transport.Close()
+	}
+}
+
+func TestGetBatchesRefused(t *testing.T) {
+	// a v1 agent without .1.3.6.1.2.1.2.2.1.10.70
+	x := testClient(t, func(request *SnmpPacket) (*SnmpPacket, []SnmpPDU) {
+		response, pdus := echoIndex(request)
+		for i, v := range request.Variables {
+			if v.Name == ".1.3.6.1.2.1.2.2.1.10.70" {
+				response.Error = NoSuchName
+				response.ErrorIndex = uint32(i + 1)
+				return response, request.Variables
+			}
+		}
+		return response, pdus
+	})
+	defer x.Transport.Close()
+	x.MaxOids = 25
+
+	var oids []string
+	for i := 1; i <= 100; i++ {
+		oids = append(oids, fmt.Sprintf(".1.3.6.1.2.1.2.2.1.10.%d", i))
+	}
+	result, err := x.Get(oids)
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) {
+		t.Fatalf("Get() got err %v, expected a *RequestError", err)
+	}
+	if reqErr.Status != NoSuchName || reqErr.Index != 70 || reqErr.Varbind.Name != oids[69] {
+		t.Errorf("Get() got %v, expected noSuchName at varbind 70", reqErr)
+	}
+	if result.Error != NoSuchName || result.ErrorIndex != 70 {
+		t.Errorf("Get() got error-status %d at index %d, expected %d at 70",
+			result.Error, result.ErrorIndex, NoSuchName)
+	}
+	// the fourth batch isn't sent
+	if len(result.Variables) != 75 {
+		t.Errorf("Get() got %d variables, expected 75", len(result.Variables))
+	}
+}
+
+// nextAgent answers GETNEXT and GETBULK requests from mib, in MIB order, with
+// each oid's last arc as its value. GETBULK repeaters are interleaved a row at
+// a time; past the end of mib SNMPv1 requests fail with noSuchName.
+func nextAgent(mib []string) func(request *SnmpPacket) (*SnmpPacket, []SnmpPDU) {
+	next := func(name string) (SnmpPDU, bool) {
+		oid, _ := ParseOID(name)
+		for _, candidate := range mib {
+			if n, _ := ParseOID(candidate); n.Compare(oid) > 0 {
+				return SnmpPDU{Name: candidate, Type: Integer, Value: int(n[len(n)-1])}, true
+			}
+		}
+		return SnmpPDU{Name: name, Type: EndOfMibView, Value: nil}, false
+	}
+	return func(request *SnmpPacket) (*SnmpPacket, []SnmpPDU) {
+		response := &SnmpPacket{Version: request.Version, Community: request.Community, PDUType: GetResponse}
+		reps := 1
+		if request.PDUType == GetBulkRequest {
+			reps = int(request.MaxRepetitions)
+		}
+		current := make([]string, len(request.Variables))
+		for i, v := range request.Variables {
+			current[i] = v.Name
+		}
+		var pdus []SnmpPDU
+		for r := 0; r < reps; r++ {
+			for i := range current {
+				pdu, ok := next(current[i])
+				if !ok && request.Version == Version1 {
+					response.Error = NoSuchName
+					response.ErrorIndex = uint32(i + 1)
+					return response, request.Variables
+				}
+				pdus = append(pdus, pdu)
+				current[i] = pdu.Name
+			}
+		}
+		return response, pdus
+	}
+}
+
+func TestWalkSubtreeBoundary(t *testing.T) {
+	// ifIndex then ifInOctets: .1.3.6.1.2.1.2.2.1.10 shares the string prefix
+	// .1.3.6.1.2.1.2.2.1.1
+	x := testClient(t, nextAgent([]string{
+		".1.3.6.1.2.1.2.2.1.1.1",
+		".1.3.6.1.2.1.2.2.1.1.2",
+		".1.3.6.1.2.1.2.2.1.10.1",
+		".1.3.6.1.2.1.2.2.1.10.2",
+	}))
+	defer x.Transport.Close()
+	x.MaxRepetitions = 3
+
+	for _, walk := range []func(string) ([]SnmpPDU, error){x.WalkAll, x.BulkWalkAll} {
+		results, err := walk(".1.3.6.1.2.1.2.2.1.1")
+		if err != nil {
+			t.Fatalf("walk err returned: %v", err)
+		}
+		if len(results) != 2 || results[1].Name != ".1.3.6.1.2.1.2.2.1.1.2" {
+			t.Errorf("walk got %v, expected the 2 ifIndex rows", results)
+		}
+	}
+}
+
+func TestBulkWalkColumns(t *testing.T) {
+	var mib []string
+	for column, rows := range []int{30, 10, 20} {
+		for row := 1; row <= rows; row++ {
+			mib = append(mib, fmt.Sprintf(".1.3.6.1.2.1.2.2.1.%d.%d", column+1, row))
+		}
+	}
+	var repeaters []int
+	agent := nextAgent(mib)
+	x := testClient(t, func(request *SnmpPacket) (*SnmpPacket, []SnmpPDU) {
+		repeaters = append(repeaters, len(request.Variables))
+		return agent(request)
+	})
+	defer x.Transport.Close()
+	x.MaxRepetitions = 10
+
+	roots := []string{".1.3.6.1.2.1.2.2.1.1", ".1.3.6.1.2.1.2.2.1.2", ".1.3.6.1.2.1.2.2.1.3"}
+	rows := make([]int, len(roots))
+	err := x.BulkWalkColumns(roots, func(column int, dataUnit SnmpPDU) error {
+		rows[column]++
+		if expected := fmt.Sprintf("%s.%d", roots[column], rows[column]); dataUnit.Name != expected {
+			t.Errorf("column %d got %s, expected %s", column, dataUnit.Name, expected)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("BulkWalkColumns() err returned: %v", err)
+	}
+	if fmt.Sprint(rows) != "[30 10 20]" {
+		t.Errorf("BulkWalkColumns() got %v rows, expected [30 10 20]", rows)
+	}
+	// columns are dropped once they end: the second after 10 rows, the third
+	// after 20 - rather than 4 + 2 + 3 separate requests
+	if fmt.Sprint(repeaters) != "[3 3 2 1]" {
+		t.Errorf("BulkWalkColumns() sent requests of %v repeaters, expected [3 3 2 1]", repeaters)
+	}
+}
+
+func TestBulkWalkAdaptive(t *testing.T) {
+	var mib []string
+	for i := 1; i <= 1000; i++ {
+		mib = append(mib, fmt.Sprintf(".1.3.6.1.2.1.4.20.1.1.%d", i))
+	}
+	agent := nextAgent(mib)
+
+	// an agent that can't fit more than 300 repetitions in a response, and
+	// one that is too slow to answer for more than 40
+	for _, limit := range []uint32{300, 40} {
+		var maxReps []uint32
+		x := testClient(t, func(request *SnmpPacket) (*SnmpPacket, []SnmpPDU) {
+			maxReps = append(maxReps, request.MaxRepetitions)
+			if request.MaxRepetitions > limit {
+				if limit == 40 {
+					return nil, nil
+				}
+				return &SnmpPacket{Version: request.Version, Community: request.Community,
+					PDUType: GetResponse, Error: TooBig}, []SnmpPDU{}
+			}
+			return agent(request)
+		})
+		x.MaxRepetitions = 10
+		x.AdaptiveReps = true
+		x.Timeout = 200 * time.Millisecond
+		x.Retries = 0
+
+		var learned uint32
+		var second int
+		for walk := 0; walk < 2; walk++ {
+			learned, second = x.reps[":0"].reps, len(maxReps)
+			results, err := x.BulkWalkAll(".1.3.6.1.2.1.4.20.1.1")
+			if err != nil {
+				t.Fatalf("limit %d: BulkWalkAll() err returned: %v", limit, err)
+			}
+			if len(results) != len(mib) || results[len(mib)-1].Name != mib[len(mib)-1] {
+				t.Errorf("limit %d: BulkWalkAll() got %d results", limit, len(results))
+			}
+		}
+		x.Transport.Close()
+
+		highest := uint32(0)
+		for _, reps := range maxReps {
+			if reps > highest {
+				highest = reps
+			}
+		}
+		// grown from 10, beyond the 255 of a uint8 if the agent allows
+		if highest <= limit || (limit == 300 && highest <= 255) {
+			t.Errorf("limit %d: max-repetitions only grew to %d: %v", limit, highest, maxReps)
+		}
+		// the second walk starts with the max-repetitions learned by the first
+		if maxReps[0] != 10 || learned <= 10 || learned > limit || maxReps[second] != learned {
+			t.Errorf("limit %d: max-repetitions learned %v: %v", limit, x.reps, maxReps)
+		}
+	}
+}
+
+func TestWalkNotIncreasing(t *testing.T) {
+	// an agent that loops back to the first row of the table
+	x := testClient(t, func(request *SnmpPacket) (*SnmpPacket, []SnmpPDU) {
+		response := &SnmpPacket{Version: request.Version, Community: request.Community, PDUType: GetResponse}
+		name := ".1.3.6.1.2.1.2.2.1.1.2"
+		if request.Variables[0].Name == name {
+			name = ".1.3.6.1.2.1.2.2.1.1.1"
+		}
+		return response, []SnmpPDU{{Name: name, Type: Integer, Value: 1}}
+	})
+	defer x.Transport.Close()
+
+	_, err := x.WalkAll(".1.3.6.1.2.1.2.2.1.1")
+	if err == nil || !strings.Contains(err.Error(), "OID not increasing") {
+		t.Errorf("WalkAll() got err %v, expected OID not increasing", err)
+	}
+}
+
+func TestBulkWalkTooBig(t *testing.T) {
+	// a 35 row table, and an agent that can't fit more than 10 repetitions
+	var maxReps []int
+	x := testClient(t, func(request *SnmpPacket) (*SnmpPacket, []SnmpPDU) {
+		maxReps = append(maxReps, int(request.MaxRepetitions))
+		response := &SnmpPacket{Version: request.Version, Community: request.Community, PDUType: GetResponse}
+		if request.MaxRepetitions > 10 {
+			response.Error = TooBig
+			return response, []SnmpPDU{}
+		}
+		var index int
+		fmt.Sscanf(strings.TrimPrefix(request.Variables[0].Name, ".1.3.6.1.2.1.2.2.1.1."), "%d", &index)
+		var pdus []SnmpPDU
+		for i := index + 1; i <= index+int(request.MaxRepetitions); i++ {
+			if i > 35 {
+				pdus = append(pdus, SnmpPDU{Name: ".1.3.6.1.2.1.2.2.1.2.1", Type: OctetString, Value: "lo"})
+				break
+			}
+			pdus = append(pdus, SnmpPDU{Name: fmt.Sprintf(".1.3.6.1.2.1.2.2.1.1.%d", i), Type: Integer, Value: i})
+		}
+		return response, pdus
+	})
+	defer x.Transport.Close()
+
+	results, err := x.BulkWalkAll(".1.3.6.1.2.1.2.2.1.1")
+	if err != nil {
+		t.Fatalf("BulkWalkAll() err returned: %v", err)
+	}
+	if len(results) != 35 {
+		t.Errorf("BulkWalkAll() got %d results, expected 35", len(results))
+	}
+	// halved from the default of 50 until it fits, then kept
+	expected := "[50 25 12 6 6 6 6 6 6]"
+	if got := fmt.Sprint(maxReps); got != expected {
+		t.Errorf("BulkWalkAll() sent max-repetitions %s, expected %s", got, expected)
+	}
+}
+
+// -- Version negotiation ------------------------------------------------------
+
+// versionAgent answers requests of the supported versions from mib, as
+// nextAgent, and drops the rest. SNMPv3 requests are answered with a
+// discovery Report.
+func versionAgent(mib []string, supported ...SnmpVersion) func(request *SnmpPacket) (*SnmpPacket, []SnmpPDU) {
+	next := nextAgent(mib)
+	return func(request *SnmpPacket) (*SnmpPacket, []SnmpPDU) {
+		ok := false
+		for _, version := range supported {
+			ok = ok || version == request.Version
+		}
+		switch {
+		case !ok:
+			return nil, nil
+		case request.Version == Version3:
+			return &SnmpPacket{
+				Version:       Version3,
+				MsgFlags:      NoAuthNoPriv,
+				SecurityModel: UserSecurityModel,
+				SecurityParameters: &UsmSecurityParameters{
+					AuthoritativeEngineID:    "\x80\x00\x1f\x88\x04test",
+					AuthoritativeEngineBoots: 1,
+				},
+				PDUType: Report,
+				MsgID:   request.MsgID,
+			}, []SnmpPDU{{Name: usmStatsUnknownEngineIDs, Type: Integer, Value: 1}}
+		case request.PDUType != GetRequest:
+			return next(request)
+		}
+		response := &SnmpPacket{Version: request.Version, Community: request.Community, PDUType: GetResponse}
+		for i, v := range request.Variables {
+			found := false
+			for _, name := range mib {
+				found = found || name == v.Name
+			}
+			if !found && request.Version == Version1 {
+				response.Error = NoSuchName
+				response.ErrorIndex = uint32(i + 1)
+				return response, request.Variables
+			}
+		}
+		return echoIndex(request)
+	}
+}
+
+var testsNegotiate = []struct {
+	supported []SnmpVersion
+	usm       bool
+	version   SnmpVersion
+	ok        bool
+}{
+	{[]SnmpVersion{Version1}, true, Version1, true},
+	{[]SnmpVersion{Version1, Version2c}, true, Version2c, true},
+	{[]SnmpVersion{Version1, Version2c, Version3}, true, Version3, true},
+	{[]SnmpVersion{Version1, Version2c, Version3}, false, Version2c, true},
+	{[]SnmpVersion{Version3}, true, Version3, true},
+	{nil, true, Version2c, false},
+}
+
+func TestNegotiate(t *testing.T) {
+	for i, test := range testsNegotiate {
+		x := testClient(t, versionAgent([]string{sysUpTime}, test.supported...))
+		x.Negotiate = true
+		x.Timeout = 100 * time.Millisecond
+		x.Retries = 0
+		if test.usm {
+			x.MsgFlags = NoAuthNoPriv
+			x.SecurityParameters = &UsmSecurityParameters{UserName: "gosnmp"}
+		}
+
+		err := x.Connect()
+		x.Transport.Close()
+		if (err == nil) != test.ok {
+			t.Errorf("#%d: Connect() got err %v", i, err)
+			continue
+		}
+		if test.ok && x.Version != test.version {
+			t.Errorf("#%d: Connect() negotiated %s, expected %s", i, x.Version, test.version)
+		}
+	}
+}
+
+func TestNegotiateV1Exceptions(t *testing.T) {
+	mib := []string{
+		".1.3.6.1.2.1.2.2.1.1.1",
+		".1.3.6.1.2.1.2.2.1.1.2",
+		".1.3.6.1.2.1.2.2.1.1.3",
+	}
+	x := testClient(t, versionAgent(mib, Version1))
+	defer x.Transport.Close()
+	x.Negotiate = true
+	x.Timeout = 200 * time.Millisecond
+	x.Retries = 0
+	if err := x.Connect(); err != nil || x.Version != Version1 {
+		t.Fatalf("Connect() negotiated %s, err %v", x.Version, err)
+	}
+
+	result, err := x.Get([]string{mib[0], ".1.3.6.1.2.1.2.2.1.1.9", ".1.3.6.1.2.1.2.2.1.1.8", mib[2]})
+	if err != nil {
+		t.Fatalf("Get() err returned: %v", err)
+	}
+	types := fmt.Sprint(result.Error)
+	for _, v := range result.Variables {
+		types += fmt.Sprintf(" %#x", v.Type)
+	}
+	if types != "noError 0x2 0x80 0x80 0x2" || result.Variables[2].Name != ".1.3.6.1.2.1.2.2.1.1.8" {
+		t.Errorf("Get() got error-status and types %s, variables %v", types, result.Variables)
+	}
+
+	result, err = x.GetNext([]string{mib[2], mib[0]})
+	if err != nil || len(result.Variables) != 2 || result.Variables[0].Type != EndOfMibView ||
+		result.Variables[1].Name != mib[1] {
+		t.Errorf("GetNext() got %v, err %v", result.Variables, err)
+	}
+
+	// BulkWalk degrades to Walk, and ends at the end of the MIB
+	for _, negotiate := range []bool{true, false} {
+		x.Negotiate = negotiate
+		results, err := x.BulkWalkAll(".1.3.6.1.2.1.2.2.1.1")
+		if err != nil || len(results) != len(mib) {
+			t.Errorf("BulkWalkAll() got %v, err %v", results, err)
+		}
+	}
+}
+
+// an agent names an exception after the requested OID
+var testsWalkExceptions = []Asn1BER{EndOfMibView, NoSuchObject, NoSuchInstance}
+
+func TestWalkException(t *testing.T) {
+	const root = ".1.3.6.1.4.1.9999.1"
+	for _, exception := range testsWalkExceptions {
+		x := testClient(t, func(request *SnmpPacket) (*SnmpPacket, []SnmpPDU) {
+			response := &SnmpPacket{
+				Version:   request.Version,
+				Community: request.Community,
+				PDUType:   GetResponse,
+			}
+			name := request.Variables[0].Name
+			if name == root {
+				return response, []SnmpPDU{{Name: root + ".1", Type: Integer, Value: 1}}
+			}
+			return response, []SnmpPDU{{Name: name, Type: exception, Value: nil}}
+		})
+
+		for _, walk := range []func(string) ([]SnmpPDU, error){x.WalkAll, x.BulkWalkAll} {
+			results, err := walk(root)
+			if err != nil {
+				t.Errorf("%#x: walk err returned: %v", exception, err)
+			} else if len(results) != 1 || results[0].Name != root+".1" {
+				t.Errorf("%#x: walk got %v, expected only %s.1", exception, results, root)
+			}
+		}
+		x.Transport.Close()
+	}
+}
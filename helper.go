@@ -30,57 +30,58 @@ type variable struct {
 
 // -- helper functions (mostly) in alphabetical order --------------------------
 
-func decodeValue(data []byte, msg string) (retVal *variable, err error) {
-	if LoggingDisabled != true {
-		dumpBytes1(data, fmt.Sprintf("decodeValue: %s", msg), 16)
-	}
+func (x *GoSNMP) decodeValue(data []byte, msg string) (retVal *variable, err error) {
+	dumpBytes1(x.Logger, data, fmt.Sprintf("decodeValue: %s", msg), 16)
 	retVal = new(variable)
 
 	switch Asn1BER(data[0]) {
 
+	case Boolean:
+		// 0x01
+		x.logPrint("decodeValue: type is Boolean")
+		length, cursor := parseLength(data)
+		if length-cursor != 1 {
+			return nil, fmt.Errorf("got boolean len %d, expected 1", length-cursor)
+		}
+		retVal.Type = Boolean
+		retVal.Value = data[cursor] != 0
 	case Integer:
 		// 0x02. signed
-		if LoggingDisabled != true {
-			slog.Print("decodeValue: type is Integer")
-		}
+		x.logPrint("decodeValue: type is Integer")
 		length, cursor := parseLength(data)
 		var ret int
 		var err error
 		if ret, err = parseInt(data[cursor:length]); err != nil {
-			if LoggingDisabled != true {
-				slog.Printf("%v:", err)
-			}
+			x.logPrintf("%v:", err)
 			return retVal, fmt.Errorf("bytes: % x err: %v", data, err)
 		}
 		retVal.Type = Integer
 		retVal.Value = ret
+	case BitString:
+		// 0x03
+		x.logPrint("decodeValue: type is BitString")
+		length, cursor := parseLength(data)
+		ret, err := parseBitString(data[cursor:length])
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing BitString Value: %s", err.Error())
+		}
+		retVal.Type = BitString
+		retVal.Value = ret
 	case OctetString:
 		// 0x04
-		if LoggingDisabled != true {
-			slog.Print("decodeValue: type is OctetString")
-		}
+		x.logPrint("decodeValue: type is OctetString")
 		length, cursor := parseLength(data)
 		retVal.Type = OctetString
-		if data[cursor] == 0 && length == 2 {
-			retVal.Value = ""
-		} else if data[cursor] == 0 {
-			retVal.Value = fmt.Sprintf("% x", data[cursor:length])
-		} else {
-			retVal.Value = string(data[cursor:length])
-		}
+		retVal.Value = OctetStringValue(append([]byte{}, data[cursor:length]...))
 	case Null:
 		// 0x05
-		if LoggingDisabled != true {
-			slog.Print("decodeValue: type is Null")
-		}
+		x.logPrint("decodeValue: type is Null")
 		retVal.Type = Null
 		retVal.Value = nil
 	case ObjectIdentifier:
 		// 0x06
-		if LoggingDisabled != true {
-			slog.Print("decodeValue: type is ObjectIdentifier")
-		}
-		rawOid, _, err := parseRawField(data, "OID")
+		x.logPrint("decodeValue: type is ObjectIdentifier")
+		rawOid, _, err := parseRawField(x.Logger, data, "OID")
 		if err != nil {
 			return nil, fmt.Errorf("Error parsing OID Value: %s", err.Error())
 		}
@@ -91,11 +92,15 @@ func decodeValue(data []byte, msg string) (retVal *variable, err error) {
 		}
 		retVal.Type = ObjectIdentifier
 		retVal.Value = oidToString(oid)
+	case ObjectDescription, Opaque, NsapAddress:
+		// 0x07, 0x44, 0x45. raw octets
+		x.logPrintf("decodeValue: type is %#x", data[0])
+		length, cursor := parseLength(data)
+		retVal.Type = Asn1BER(data[0])
+		retVal.Value = append([]byte(nil), data[cursor:length]...)
 	case IPAddress:
 		// 0x40
-		if LoggingDisabled != true {
-			slog.Print("decodeValue: type is IPAddress")
-		}
+		x.logPrint("decodeValue: type is IPAddress")
 		retVal.Type = IPAddress
 		switch data[1] {
 		case 4: // IPv4
@@ -108,107 +113,95 @@ func decodeValue(data []byte, msg string) (retVal *variable, err error) {
 				return nil, fmt.Errorf("not enough data for ipv6 address: %x", data)
 			}
 			d := make(net.IP, 16)
-			copy(d, data[2:17])
+			copy(d, data[2:18])
 			retVal.Value = d.String()
 		default:
 			return nil, fmt.Errorf("got ipaddress len %d, expected 4 or 16", data[1])
 		}
 	case Counter32:
 		// 0x41. unsigned
-		if LoggingDisabled != true {
-			slog.Print("decodeValue: type is Counter32")
-		}
+		x.logPrint("decodeValue: type is Counter32")
 		length, cursor := parseLength(data)
 		ret, err := parseUint(data[cursor:length])
 		if err != nil {
-			if LoggingDisabled != true {
-				slog.Printf("decodeValue: err is %v", err)
-			}
+			x.logPrintf("decodeValue: err is %v", err)
 			break
 		}
 		retVal.Type = Counter32
 		retVal.Value = ret
 	case Gauge32:
 		// 0x42. unsigned
-		if LoggingDisabled != true {
-			slog.Print("decodeValue: type is Gauge32")
-		}
+		x.logPrint("decodeValue: type is Gauge32")
 		length, cursor := parseLength(data)
 		ret, err := parseUint(data[cursor:length])
 		if err != nil {
-			if LoggingDisabled != true {
-				slog.Printf("decodeValue: err is %v", err)
-			}
+			x.logPrintf("decodeValue: err is %v", err)
 			break
 		}
 		retVal.Type = Gauge32
 		retVal.Value = ret
 	case TimeTicks:
 		// 0x43
-		if LoggingDisabled != true {
-			slog.Print("decodeValue: type is TimeTicks")
-		}
+		x.logPrint("decodeValue: type is TimeTicks")
 		length, cursor := parseLength(data)
 		ret, err := parseInt(data[cursor:length])
 		if err != nil {
-			if LoggingDisabled != true {
-				slog.Printf("decodeValue: err is %v", err)
-			}
+			x.logPrintf("decodeValue: err is %v", err)
 			break
 		}
 		retVal.Type = TimeTicks
 		retVal.Value = ret
 	case Counter64:
 		// 0x46
-		if LoggingDisabled != true {
-			slog.Print("decodeValue: type is Counter64")
-		}
+		x.logPrint("decodeValue: type is Counter64")
 		length, cursor := parseLength(data)
 		ret, err := parseInt64(data[cursor:length])
 		if err != nil {
-			if LoggingDisabled != true {
-				slog.Printf("decodeValue: err is %v", err)
-			}
+			x.logPrintf("decodeValue: err is %v", err)
 			break
 		}
 		retVal.Type = Counter64
 		retVal.Value = ret
+	case Uinteger32:
+		// 0x47. unsigned
+		x.logPrint("decodeValue: type is Uinteger32")
+		length, cursor := parseLength(data)
+		ret, err := parseUint(data[cursor:length])
+		if err != nil {
+			x.logPrintf("decodeValue: err is %v", err)
+			break
+		}
+		retVal.Type = Uinteger32
+		retVal.Value = ret
 	case NoSuchObject:
 		// 0x80
-		if LoggingDisabled != true {
-			slog.Print("decodeValue: type is NoSuchObject")
-		}
+		x.logPrint("decodeValue: type is NoSuchObject")
 		retVal.Type = NoSuchObject
 		retVal.Value = nil
 	case NoSuchInstance:
 		// 0x81
-		if LoggingDisabled != true {
-			slog.Print("decodeValue: type is NoSuchInstance")
-		}
+		x.logPrint("decodeValue: type is NoSuchInstance")
 		retVal.Type = NoSuchInstance
 		retVal.Value = nil
 	case EndOfMibView:
 		// 0x82
-		if LoggingDisabled != true {
-			slog.Print("decodeValue: type is EndOfMibView")
-		}
+		x.logPrint("decodeValue: type is EndOfMibView")
 		retVal.Type = EndOfMibView
 		retVal.Value = nil
 	default:
-		if LoggingDisabled != true {
-			slog.Print("decodeValue: type %x isn't implemented", data[0])
-		}
+		x.logPrintf("decodeValue: type %x isn't implemented", data[0])
 		retVal.Type = UnknownType
 		retVal.Value = nil
 	}
-	if LoggingDisabled != true {
-		slog.Printf("decodeValue: value is %#v", retVal.Value)
-	}
+	x.logPrintf("decodeValue: value is %#v", retVal.Value)
 	return
 }
 
 // dump bytes in a format similar to Wireshark
-func dumpBytes1(data []byte, msg string, maxlength int) {
+func dumpBytes1(logger Logger, data []byte, msg string, maxlength int) {
+	if logger == nil {
+		return
+	}
 	var buffer bytes.Buffer
 	buffer.WriteString(msg)
 	length := maxlength
@@ -248,7 +241,7 @@ func dumpBytes1(data []byte, msg string, maxlength int) {
 		}
 	}
 	buffer.WriteString("\n")
-	slog.Print(buffer.String())
+	logger.Print(buffer.String())
 }
 
 // dump bytes in one row, up to about screen width. Returns a string
@@ -294,6 +287,24 @@ func marshalBase128Int(out *bytes.Buffer, n int64) (err error) {
 	return nil
 }
 
+// marshalInt64 builds the minimal two's complement content octets of a BER
+// integer, as described in X.690 8.3.2
+func marshalInt64(v int64) []byte {
+	bs := make([]byte, 8)
+	binary.BigEndian.PutUint64(bs, uint64(v))
+	i := 0
+	for i < 7 {
+		// a leading octet is redundant if it and the top bit of the
+		// following octet are all zeros or all ones
+		if bs[i] == 0 && bs[i+1]&0x80 == 0 || bs[i] == 0xff && bs[i+1]&0x80 != 0 {
+			i++
+			continue
+		}
+		break
+	}
+	return bs[i:]
+}
+
 // marshalLength builds a byte representation of length
 //
 // http://luca.ntop.org/Teaching/Appunti/asn1.html
@@ -315,16 +326,11 @@ func marshalLength(length int) ([]byte, error) {
 	}
 
 	buf := new(bytes.Buffer)
-	err := binary.Write(buf, binary.LittleEndian, uint64(length))
+	err := binary.Write(buf, binary.BigEndian, uint64(length))
 	if err != nil {
 		return nil, err
 	}
-
-	bufBytes, err2 := buf.ReadBytes(0) // can't use buf.Bytes() - trailing 00's
-	if err2 != nil {
-		return nil, err
-	}
-	bufBytes = bufBytes[0 : len(bufBytes)-1] // remove trailing 00
+	bufBytes := bytes.TrimLeft(buf.Bytes(), "\x00") // base 256, most significant first
 
 	header := []byte{byte(128 | len(bufBytes))}
 	return append(header, bufBytes...), nil
@@ -352,21 +358,17 @@ func marshalObjectIdentifier(oid []int) (ret []byte, err error) {
 }
 
 func marshalOID(oid string) ([]byte, error) {
-	var err error
-
-	// Encode the oid
-	oid = strings.Trim(oid, ".")
-	oidParts := strings.Split(oid, ".")
-	oidBytes := make([]int, len(oidParts))
-
 	// Convert the string OID to an array of integers
-	for i := 0; i < len(oidParts); i++ {
-		oidBytes[i], err = strconv.Atoi(oidParts[i])
-		if err != nil {
-			return nil, fmt.Errorf("Unable to parse OID: %s\n", err.Error())
-		}
+	parsed, err := ParseOID(strings.Trim(oid, "."))
+	if err != nil {
+		return nil, err
+	}
+	oidBytes := make([]int, len(parsed))
+	for i, n := range parsed {
+		oidBytes[i] = int(n)
 	}
 
+	// Encode the oid
 	mOid, err := marshalObjectIdentifier(oidBytes)
 
 	if err != nil {
@@ -376,12 +378,48 @@ func marshalOID(oid string) ([]byte, error) {
 	return mOid, err
 }
 
+// marshalTLV builds the BER encoding of value: the tag, the length octets,
+// then the value itself
+func marshalTLV(tag byte, value []byte) ([]byte, error) {
+	length, err := marshalLength(len(value))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, 1+len(length)+len(value))
+	out = append(out, tag)
+	out = append(out, length...)
+	return append(out, value...), nil
+}
+
+// marshalUint32 builds the minimal content octets of a BER integer for a
+// non-negative value. A leading zero octet is kept when the most significant
+// bit is set, so the value isn't read back as negative.
+func marshalUint32(v uint32) []byte {
+	return marshalUint64(uint64(v))
+}
+
+// marshalUint64 is marshalUint32 for 64 bit values, eg Counter64
+func marshalUint64(v uint64) []byte {
+	bs := make([]byte, 8)
+	binary.BigEndian.PutUint64(bs, v)
+	i := 0
+	for i < 7 && bs[i] == 0 && bs[i+1]&0x80 == 0 {
+		i++
+	}
+	bs = bs[i:]
+	if bs[0]&0x80 != 0 {
+		bs = append([]byte{0}, bs...)
+	}
+	return bs
+}
+
 func oidToString(oid []int) (ret string) {
-	values := make([]interface{}, len(oid))
-	for i, v := range oid {
-		values[i] = v
+	b := make([]byte, 0, 4*len(oid))
+	for _, v := range oid {
+		b = append(b, '.')
+		b = strconv.AppendInt(b, int64(v), 10)
 	}
-	return fmt.Sprintf(strings.Repeat(".%d", len(oid)), values...)
+	return string(b)
 }
 
 // parseBase128Int parses a base-128 encoded int from the given offset in the
@@ -468,9 +506,9 @@ func parseInt(bytes []byte) (int, error) {
 //   octets give the length, base 256, most significant digit first.
 func parseLength(bytes []byte) (length int, cursor int) {
 	if len(bytes) <= 2 {
-		// handle null octet strings ie "0x04 0x00"
-		cursor = 1
-		length = 2
+		// handle null octet strings ie "0x04 0x00", with no content
+		cursor = len(bytes)
+		length = len(bytes)
 	} else if int(bytes[1]) <= 127 {
 		length = int(bytes[1])
 		length += 2
@@ -516,8 +554,8 @@ func parseObjectIdentifier(bytes []byte) (s []int, err error) {
 	return
 }
 
-func parseRawField(data []byte, msg string) (interface{}, int, error) {
-	dumpBytes1(data, fmt.Sprintf("parseRawField: %s", msg), 16)
+func parseRawField(logger Logger, data []byte, msg string) (interface{}, int, error) {
+	dumpBytes1(logger, data, fmt.Sprintf("parseRawField: %s", msg), 16)
 
 	switch Asn1BER(data[0]) {
 	case Integer:
@@ -537,12 +575,10 @@ func parseRawField(data []byte, msg string) (interface{}, int, error) {
 	default:
 		return nil, 0, fmt.Errorf("Unknown field type: %x\n", data[0])
 	}
-
-	return nil, 0, nil
 }
 
 func parseUint16(content []byte) int {
-	number := uint8(content[1]) | uint8(content[0])<<8
+	number := uint16(content[1]) | uint16(content[0])<<8
 	return int(number)
 }
 
@@ -574,6 +610,53 @@ func parseUint(bytes []byte) (uint, error) {
 	return uint(ret64), nil
 }
 
+// toInt64 converts a varbind value of any Go integer type to an int64
+func toInt64(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint, uint8, uint16, uint32, uint64:
+		u, _ := toUint64(v)
+		if u > math.MaxInt64 {
+			return 0, fmt.Errorf("value %d out of range", u)
+		}
+		return int64(u), nil
+	}
+	return 0, fmt.Errorf("value %v (%T) is not an integer", value, value)
+}
+
+// toUint64 converts a varbind value of any Go integer type to a uint64,
+// rejecting negative values
+func toUint64(value interface{}) (uint64, error) {
+	switch v := value.(type) {
+	case uint:
+		return uint64(v), nil
+	case uint8:
+		return uint64(v), nil
+	case uint16:
+		return uint64(v), nil
+	case uint32:
+		return uint64(v), nil
+	case uint64:
+		return v, nil
+	case int, int8, int16, int32, int64:
+		i, _ := toInt64(v)
+		if i < 0 {
+			return 0, fmt.Errorf("value %d is negative", i)
+		}
+		return uint64(i), nil
+	}
+	return 0, fmt.Errorf("value %v (%T) is not an integer", value, value)
+}
+
 // Issue 4389: math/big: add SetUint64 and Uint64 functions to *Int
 //
 // uint64ToBigInt copied from: http://github.com/cznic/mathutil/blob/master/mathutil.go#L341
@@ -634,11 +717,54 @@ func (b BitStringValue) RightAlign() []byte {
 	return a
 }
 
+// -- Octet String -------------------------------------------------------------
+
+// OctetStringValue is the value of a received OCTET STRING, as the raw octets.
+// OCTET STRINGs may hold text (DisplayString), or binary values eg MAC
+// addresses and BITS, so String, Hex and MAC render it as each.
+type OctetStringValue []byte
+
+// String returns the octets as text
+func (o OctetStringValue) String() string {
+	return string(o)
+}
+
+// Hex returns the octets in hex, separated by spaces eg "00 1b 21 3a"
+func (o OctetStringValue) Hex() string {
+	return fmt.Sprintf("% x", []byte(o))
+}
+
+// MAC returns the octets as a hardware address eg "00:1b:21:3a:4f:5e"
+func (o OctetStringValue) MAC() string {
+	return net.HardwareAddr(o).String()
+}
+
+// -- ErrorStatus --------------------------------------------------------------
+
+// errorStatusNames are the names of the error-status values - RFC 3416 3
+var errorStatusNames = [...]string{
+	"noError", "tooBig", "noSuchName", "badValue", "readOnly", "genErr",
+	"noAccess", "wrongType", "wrongLength", "wrongEncoding", "wrongValue",
+	"noCreation", "inconsistentValue", "resourceUnavailable", "commitFailed",
+	"undoFailed", "authorizationError", "notWritable", "inconsistentName",
+}
+
+// String returns the RFC 3416 name of the error-status eg "noSuchName"
+func (e ErrorStatus) String() string {
+	if int(e) < len(errorStatusNames) {
+		return errorStatusNames[e]
+	}
+	return fmt.Sprintf("errorStatus(%d)", uint8(e))
+}
+
 // -- SnmpVersion --------------------------------------------------------------
 
 func (s SnmpVersion) String() string {
-	if s == Version1 {
+	switch s {
+	case Version1:
 		return "1"
+	case Version3:
+		return "3"
 	}
 	return "2c"
 }
@@ -0,0 +1,101 @@
+// Copyright 2012-2014 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// udpListener is the UDP socket handling shared by TrapListener and Agent
+type udpListener struct {
+	mu        sync.Mutex
+	conn      *net.UDPConn
+	listening chan bool
+	closing   bool
+}
+
+// Listening returns a channel that is closed once Listen has bound its
+// socket, and packets can be sent to it
+func (l *udpListener) Listening() <-chan bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.listening == nil {
+		l.listening = make(chan bool)
+	}
+	return l.listening
+}
+
+// Close stops a running Listen
+func (l *udpListener) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closing = true
+	if l.conn != nil {
+		l.conn.Close()
+	}
+}
+
+// serve binds addr, then passes each received packet to handle until Close
+// is called or the socket fails. It returns nil after Close.
+func (l *udpListener) serve(addr string, params *GoSNMP, handle func(msg []byte, remote *net.UDPAddr)) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("Unable to resolve listen address %s: %s", addr, err.Error())
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return fmt.Errorf("Unable to listen on %s: %s", addr, err.Error())
+	}
+	l.mu.Lock()
+	if l.closing || l.conn != nil {
+		l.mu.Unlock()
+		conn.Close()
+		if l.closing {
+			return nil
+		}
+		return fmt.Errorf("Already listening")
+	}
+	l.conn = conn
+	if l.listening == nil {
+		l.listening = make(chan bool)
+	}
+	close(l.listening)
+	l.mu.Unlock()
+
+	buf := make([]byte, rxBufSize+1)
+	for {
+		n, remote, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			l.mu.Lock()
+			closing := l.closing
+			l.mu.Unlock()
+			if closing {
+				return nil
+			}
+			return fmt.Errorf("Error reading from UDP: %s", err.Error())
+		}
+		if n > rxBufSize {
+			params.logPrintf("Discarding a message from %s larger than %d bytes", remote, rxBufSize)
+			continue
+		}
+		msg := make([]byte, n)
+		copy(msg, buf[:n])
+		handle(msg, remote)
+	}
+}
+
+// reply sends msg to remote from the listening socket
+func (l *udpListener) reply(msg []byte, remote *net.UDPAddr) error {
+	l.mu.Lock()
+	conn := l.conn
+	l.mu.Unlock()
+	if conn == nil {
+		return fmt.Errorf("Not listening")
+	}
+	_, err := conn.WriteToUDP(msg, remote)
+	return err
+}
@@ -9,6 +9,8 @@ import (
 	"encoding/asn1"
 	"encoding/binary"
 	"fmt"
+	"math"
+	"net"
 )
 
 //
@@ -17,27 +19,41 @@ import (
 // protocol.
 //
 
-// SnmpVersion 1 and 2c implemented, 3 planned
+// SnmpVersion 1, 2c and 3 implemented
 type SnmpVersion uint8
 
-// SnmpVersion 1 and 2c implemented, 3 planned
+// SnmpVersion 1, 2c and 3 implemented
 const (
 	Version1  SnmpVersion = 0x0
 	Version2c SnmpVersion = 0x1
+	Version3  SnmpVersion = 0x3
 )
 
 // SnmpPacket struct represents the entire SNMP Message or Sequence at the
 // application layer.
 type SnmpPacket struct {
-	Version        SnmpVersion
-	Community      string
-	PDUType        PDUType
-	RequestID      uint32
-	Error          uint8
-	ErrorIndex     uint8
-	NonRepeaters   uint8
-	MaxRepetitions uint8
-	Variables      []SnmpPDU
+	Version            SnmpVersion
+	MsgFlags           SnmpV3MsgFlags
+	SecurityModel      SnmpV3SecurityModel
+	SecurityParameters *UsmSecurityParameters
+	ContextEngineID    string
+	ContextName        string
+	Community          string
+	PDUType            PDUType
+	MsgID              uint32
+	RequestID          uint32
+	Error              ErrorStatus
+	ErrorIndex         uint32
+	NonRepeaters       uint32
+	MaxRepetitions     uint32
+	Variables          []SnmpPDU
+
+	// SNMPv1 Trap-PDU fields
+	Enterprise   string // Enterprise is the sysObjectID of the trap sender
+	AgentAddress string // AgentAddress is the IPv4 address of the trap sender
+	GenericTrap  int    // GenericTrap is eg 0 coldStart .. 6 enterpriseSpecific
+	SpecificTrap int    // SpecificTrap is the enterprise specific trap code
+	Timestamp    uint   // Timestamp is the sender's sysUpTime, in TimeTicks
 }
 
 // VarBind struct represents an SNMP Varbind.
@@ -58,6 +74,36 @@ const (
 	SetRequest     PDUType = 0xa3
 	Trap           PDUType = 0xa4
 	GetBulkRequest PDUType = 0xa5
+	InformRequest  PDUType = 0xa6
+	SNMPv2Trap     PDUType = 0xa7
+	Report         PDUType = 0xa8
+)
+
+// ErrorStatus is the error-status of a response - RFC 3416 3. A response
+// with an error-status too large for it can't be decoded.
+type ErrorStatus uint8
+
+// SNMP error-status values of a response - RFC 3416 3
+const (
+	NoError             ErrorStatus = 0
+	TooBig              ErrorStatus = 1
+	NoSuchName          ErrorStatus = 2
+	BadValue            ErrorStatus = 3
+	ReadOnly            ErrorStatus = 4
+	GenErr              ErrorStatus = 5
+	NoAccess            ErrorStatus = 6
+	WrongType           ErrorStatus = 7
+	WrongLength         ErrorStatus = 8
+	WrongEncoding       ErrorStatus = 9
+	WrongValue          ErrorStatus = 10
+	NoCreation          ErrorStatus = 11
+	InconsistentValue   ErrorStatus = 12
+	ResourceUnavailable ErrorStatus = 13
+	CommitFailed        ErrorStatus = 14
+	UndoFailed          ErrorStatus = 15
+	AuthorizationError  ErrorStatus = 16
+	NotWritable         ErrorStatus = 17
+	InconsistentName    ErrorStatus = 18
 )
 
 const (
@@ -77,14 +123,14 @@ type Logger interface {
 	Printf(format string, v ...interface{})
 }
 
-// slog is a global variable that is used for debug logging
-var slog Logger
-
 // -- Marshalling Logic --------------------------------------------------------
 
 // marshal an SNMP message
 func (packet *SnmpPacket) marshalMsg(pdus []SnmpPDU,
 	pdutype PDUType, requestID uint32) ([]byte, error) {
+	if packet.Version == Version3 {
+		return packet.marshalV3(pdus, requestID)
+	}
 	buf := new(bytes.Buffer)
 
 	// version
@@ -117,6 +163,9 @@ func (packet *SnmpPacket) marshalMsg(pdus []SnmpPDU,
 
 // marshal a PDU
 func (packet *SnmpPacket) marshalPDU(pdus []SnmpPDU, requestid uint32) ([]byte, error) {
+	if packet.PDUType == Trap {
+		return packet.marshalTrapV1(pdus)
+	}
 	buf := new(bytes.Buffer)
 
 	// requestid
@@ -128,17 +177,29 @@ func (packet *SnmpPacket) marshalPDU(pdus []SnmpPDU, requestid uint32) ([]byte,
 
 	if packet.PDUType == GetBulkRequest {
 		// non repeaters
-		buf.Write([]byte{2, 1, packet.NonRepeaters})
+		nonRepeaters, err := marshalTLV(Integer, marshalUint32(packet.NonRepeaters))
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(nonRepeaters)
 
 		// max repetitions
-		buf.Write([]byte{2, 1, packet.MaxRepetitions})
+		maxRepetitions, err := marshalTLV(Integer, marshalUint32(packet.MaxRepetitions))
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(maxRepetitions)
 	} else { // get and getnext have same packet format
 
 		// error
-		buf.Write([]byte{2, 1, 0})
+		buf.Write([]byte{2, 1, byte(packet.Error)})
 
 		// error index
-		buf.Write([]byte{2, 1, 0})
+		errorIndex, err := marshalTLV(Integer, marshalUint32(packet.ErrorIndex))
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(errorIndex)
 	}
 
 	// varbind list
@@ -162,6 +223,36 @@ func (packet *SnmpPacket) marshalPDU(pdus []SnmpPDU, requestid uint32) ([]byte,
 	return pdu.Bytes(), nil
 }
 
+// marshal an SNMPv1 Trap-PDU - RFC 1157 4.1.6
+func (packet *SnmpPacket) marshalTrapV1(pdus []SnmpPDU) ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	// enterprise, agent-addr, generic-trap, specific-trap, time-stamp
+	fields := []SnmpPDU{
+		{Name: "enterprise", Type: ObjectIdentifier, Value: packet.Enterprise},
+		{Name: "agent-addr", Type: IPAddress, Value: packet.AgentAddress},
+		{Name: "generic-trap", Type: Integer, Value: packet.GenericTrap},
+		{Name: "specific-trap", Type: Integer, Value: packet.SpecificTrap},
+		{Name: "time-stamp", Type: TimeTicks, Value: packet.Timestamp},
+	}
+	for i := range fields {
+		field, err := marshalValue(&fields[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(field)
+	}
+
+	// varbind list
+	vbl, err := packet.marshalVBL(pdus)
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(vbl)
+
+	return marshalTLV(byte(Trap), buf.Bytes())
+}
+
 // marshal a varbind list
 func (packet *SnmpPacket) marshalVBL(pdus []SnmpPDU) ([]byte, error) {
 
@@ -194,77 +285,188 @@ func marshalVarbind(pdu *SnmpPDU) ([]byte, error) {
 		return nil, err
 	}
 	pduBuf := new(bytes.Buffer)
-	tmpBuf := new(bytes.Buffer)
+
+	// Oid
+	oidBytes, err := marshalTLV(byte(ObjectIdentifier), oid)
+	if err != nil {
+		return nil, err
+	}
+	pduBuf.Write(oidBytes)
 
 	// Marshal the PDU type into the appropriate BER
+	value, err := marshalValue(pdu)
+	if err != nil {
+		return nil, err
+	}
+	pduBuf.Write(value)
+
+	// Sequence, length of oid + value, then oid/value data
+	return marshalTLV(byte(Sequence), pduBuf.Bytes())
+}
+
+// marshalValue builds the BER encoding of a varbind's value, according to
+// its Type. Integer types accept any Go integer type, and string types
+// accept a string or []byte.
+func marshalValue(pdu *SnmpPDU) ([]byte, error) {
+	var content []byte
 	switch pdu.Type {
-	case Null:
-		pduBuf.Write([]byte{byte(Sequence), byte(len(oid) + 4)})
-		pduBuf.Write([]byte{byte(ObjectIdentifier), byte(len(oid))})
-		pduBuf.Write(oid)
-		pduBuf.Write([]byte{Null, 0x00})
+	case Null, NoSuchObject, NoSuchInstance, EndOfMibView:
+		// no content
+	case Boolean:
+		b, ok := pdu.Value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("Unable to marshal PDU %s: Boolean value %v is not a bool", pdu.Name, pdu.Value)
+		}
+		content = []byte{0x00}
+		if b {
+			content[0] = 0xff
+		}
 	case Integer:
-		// Oid
-		tmpBuf.Write([]byte{byte(ObjectIdentifier), byte(len(oid))})
-		tmpBuf.Write(oid)
-		// Integer
-		intBytes := []byte{byte(pdu.Value.(int))}
-		tmpBuf.Write([]byte{byte(Integer), byte(len(intBytes))})
-		tmpBuf.Write(intBytes)
-		// Sequence, length of oid + integer, then oid/integer data
-		pduBuf.WriteByte(byte(Sequence))
-		pduBuf.WriteByte(byte(len(oid) + len(intBytes) + 4))
-		pduBuf.Write(tmpBuf.Bytes())
+		i, err := toInt64(pdu.Value)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to marshal PDU %s: Integer %s", pdu.Name, err.Error())
+		}
+		if i < math.MinInt32 || i > math.MaxInt32 {
+			return nil, fmt.Errorf("Unable to marshal PDU %s: Integer %d out of range", pdu.Name, i)
+		}
+		content = marshalInt64(i)
+	case Counter32, Gauge32, TimeTicks, Uinteger32:
+		u, err := toUint64(pdu.Value)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to marshal PDU %s: %#x %s", pdu.Name, pdu.Type, err.Error())
+		}
+		if u > math.MaxUint32 {
+			return nil, fmt.Errorf("Unable to marshal PDU %s: %#x value %d out of range", pdu.Name, pdu.Type, u)
+		}
+		content = marshalUint32(uint32(u))
+	case Counter64:
+		u, err := toUint64(pdu.Value)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to marshal PDU %s: Counter64 %s", pdu.Name, err.Error())
+		}
+		content = marshalUint64(u)
+	case OctetString, ObjectDescription, Opaque, NsapAddress:
+		switch value := pdu.Value.(type) {
+		case string:
+			content = []byte(value)
+		case []byte:
+			content = value
+		case OctetStringValue:
+			content = value
+		default:
+			return nil, fmt.Errorf("Unable to marshal PDU %s: %#x value %v is not a string or []byte",
+				pdu.Name, pdu.Type, pdu.Value)
+		}
+	case BitString:
+		switch value := pdu.Value.(type) {
+		case BitStringValue:
+			// leading octet is the number of unused bits in the final octet
+			padding := (8 - value.BitLength%8) % 8
+			content = append([]byte{byte(padding)}, value.Bytes...)
+		case []byte:
+			content = append([]byte{0}, value...)
+		default:
+			return nil, fmt.Errorf("Unable to marshal PDU %s: BitString value %v is not a BitStringValue or []byte",
+				pdu.Name, pdu.Value)
+		}
+	case ObjectIdentifier:
+		var err error
+		switch value := pdu.Value.(type) {
+		case string:
+			content, err = marshalOID(value)
+		case OID:
+			content, err = value.marshalContent()
+		default:
+			return nil, fmt.Errorf("Unable to marshal PDU %s: ObjectIdentifier value %v is not a string or OID", pdu.Name, pdu.Value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	case IPAddress:
+		var ip net.IP
+		switch value := pdu.Value.(type) {
+		case string:
+			ip = net.ParseIP(value)
+		case net.IP:
+			ip = value
+		}
+		if ip == nil {
+			return nil, fmt.Errorf("Unable to marshal PDU %s: invalid IPAddress %v", pdu.Name, pdu.Value)
+		}
+		if ipv4 := ip.To4(); ipv4 != nil {
+			content = ipv4
+		} else {
+			content = ip.To16()
+		}
 	default:
 		return nil, fmt.Errorf("Unable to marshal PDU: unknown BER type %d", pdu.Type)
 	}
-
-	return pduBuf.Bytes(), nil
+	return marshalTLV(byte(pdu.Type), content)
 }
 
 // -- Unmarshalling Logic ------------------------------------------------------
 
-func unmarshal(packet []byte) (*SnmpPacket, error) {
+func (x *GoSNMP) unmarshal(packet []byte) (*SnmpPacket, error) {
 	response := new(SnmpPacket)
+	cursor, err := x.unmarshalHeader(packet, response)
+	if err != nil {
+		return nil, err
+	}
+	return x.unmarshalPayload(packet, cursor, response)
+}
+
+// unmarshalHeader parses the message up to the (scoped) PDU, returning the
+// cursor of the PDU. For SNMPv3 this is the start of the scopedPDU, which
+// may need authenticating and decrypting before it can be parsed.
+func (x *GoSNMP) unmarshalHeader(packet []byte, response *SnmpPacket) (int, error) {
 	response.Variables = make([]SnmpPDU, 0, 5)
 
 	// Start parsing the packet
 	cursor := 0
 
 	// First bytes should be 0x30
-	if PDUType(packet[0]) != Sequence {
-		return nil, fmt.Errorf("Invalid packet header\n")
+	if len(packet) < 2 || PDUType(packet[0]) != Sequence {
+		return 0, fmt.Errorf("Invalid packet header\n")
 	}
 
 	length, cursor := parseLength(packet)
 	if len(packet) != length {
-		return nil, fmt.Errorf("Error verifying packet sanity: Got %d Expected: %d\n", len(packet), length)
-	}
-	if LoggingDisabled != true {
-		slog.Printf("Packet sanity verified, we got all the bytes (%d)", length)
+		return 0, fmt.Errorf("Error verifying packet sanity: Got %d Expected: %d\n", len(packet), length)
 	}
+	x.logPrintf("Packet sanity verified, we got all the bytes (%d)", length)
 
 	// Parse SNMP Version
-	rawVersion, count, err := parseRawField(packet[cursor:], "version")
+	rawVersion, count, err := parseRawField(x.Logger, packet[cursor:], "version")
 	if err != nil {
-		return nil, fmt.Errorf("Error parsing SNMP packet version: %s", err.Error())
+		return 0, fmt.Errorf("Error parsing SNMP packet version: %s", err.Error())
 	}
 
 	cursor += count
 	if version, ok := rawVersion.(int); ok {
 		response.Version = SnmpVersion(version)
-		if LoggingDisabled != true {
-			slog.Printf("Parsed version %d", version)
-		}
+		x.logPrintf("Parsed version %d", version)
+	}
+
+	if response.Version == Version3 {
+		return x.unmarshalV3Header(packet, cursor, response)
 	}
 
 	// Parse community
-	rawCommunity, count, err := parseRawField(packet[cursor:], "community")
+	rawCommunity, count, err := parseRawField(x.Logger, packet[cursor:], "community")
 	cursor += count
 	if community, ok := rawCommunity.(string); ok {
 		response.Community = community
-		if LoggingDisabled != true {
-			slog.Printf("Parsed community %s", community)
+		x.logPrintf("Parsed community %s", community)
+	}
+	return cursor, nil
+}
+
+// unmarshalPayload parses the (scoped) PDU starting at cursor
+func (x *GoSNMP) unmarshalPayload(packet []byte, cursor int, response *SnmpPacket) (*SnmpPacket, error) {
+	var err error
+	if response.Version == Version3 {
+		if cursor, err = x.unmarshalScopedPDUHeader(packet, cursor, response); err != nil {
+			return nil, err
 		}
 	}
 
@@ -272,99 +474,160 @@ func unmarshal(packet []byte) (*SnmpPacket, error) {
 	requestType := PDUType(packet[cursor])
 	switch requestType {
 	// known, supported types
-	case GetResponse, GetNextRequest, GetBulkRequest:
-		response, err = unmarshalResponse(packet[cursor:], response, length, requestType)
+	case GetRequest, GetResponse, GetNextRequest, GetBulkRequest, SetRequest,
+		InformRequest, SNMPv2Trap, Report:
+		response, err = x.unmarshalPDU(packet[cursor:], response, len(packet), requestType)
 		if err != nil {
 			return nil, fmt.Errorf("Error in unmarshalResponse: %s", err.Error())
 		}
+	case Trap:
+		response, err = x.unmarshalTrapV1(packet[cursor:], response, len(packet))
+		if err != nil {
+			return nil, fmt.Errorf("Error in unmarshalTrapV1: %s", err.Error())
+		}
 	default:
 		return nil, fmt.Errorf("Unknown PDUType %#x", requestType)
 	}
 	return response, nil
 }
 
-func unmarshalResponse(packet []byte, response *SnmpPacket, length int, requestType PDUType) (*SnmpPacket, error) {
+func (x *GoSNMP) unmarshalPDU(packet []byte, response *SnmpPacket, length int, requestType PDUType) (*SnmpPacket, error) {
 	cursor := 0
-	dumpBytes1(packet, "SNMP Packet is GET RESPONSE", 16)
+	dumpBytes1(x.Logger, packet, "SNMP Packet is GET RESPONSE", 16)
 	response.PDUType = requestType
 
 	getResponseLength, cursor := parseLength(packet)
 	if len(packet) != getResponseLength {
 		return nil, fmt.Errorf("Error verifying Response sanity: Got %d Expected: %d\n", len(packet), getResponseLength)
 	}
-	if LoggingDisabled != true {
-		slog.Printf("getResponseLength: %d", getResponseLength)
-	}
+	x.logPrintf("getResponseLength: %d", getResponseLength)
 
 	// Parse Request-ID
-	rawRequestID, count, err := parseRawField(packet[cursor:], "request id")
+	rawRequestID, count, err := parseRawField(x.Logger, packet[cursor:], "request id")
 	if err != nil {
 		return nil, fmt.Errorf("Error parsing SNMP packet request ID: %s", err.Error())
 	}
 	cursor += count
 	if requestid, ok := rawRequestID.(int); ok {
 		response.RequestID = uint32(requestid)
-		if LoggingDisabled != true {
-			slog.Printf("requestID: %d", response.RequestID)
-		}
+		x.logPrintf("requestID: %d", response.RequestID)
 	}
 
 	if response.PDUType == GetBulkRequest {
 		// Parse Non Repeaters
-		rawNonRepeaters, count, err := parseRawField(packet[cursor:], "non repeaters")
+		rawNonRepeaters, count, err := parseRawField(x.Logger, packet[cursor:], "non repeaters")
 		if err != nil {
 			return nil, fmt.Errorf("Error parsing SNMP packet non repeaters: %s", err.Error())
 		}
 		cursor += count
-		if nonRepeaters, ok := rawNonRepeaters.(int); ok {
-			response.NonRepeaters = uint8(nonRepeaters)
+		if nonRepeaters, ok := rawNonRepeaters.(int); ok && nonRepeaters >= 0 {
+			response.NonRepeaters = uint32(nonRepeaters)
 		}
 
 		// Parse Max Repetitions
-		rawMaxRepetitions, count, err := parseRawField(packet[cursor:], "max repetitions")
+		rawMaxRepetitions, count, err := parseRawField(x.Logger, packet[cursor:], "max repetitions")
 		if err != nil {
 			return nil, fmt.Errorf("Error parsing SNMP packet max repetitions: %s", err.Error())
 		}
 		cursor += count
-		if maxRepetitions, ok := rawMaxRepetitions.(int); ok {
-			response.MaxRepetitions = uint8(maxRepetitions)
+		if maxRepetitions, ok := rawMaxRepetitions.(int); ok && maxRepetitions >= 0 {
+			response.MaxRepetitions = uint32(maxRepetitions)
 		}
 	} else {
 		// Parse Error-Status
-		rawError, count, err := parseRawField(packet[cursor:], "error-status")
+		rawError, count, err := parseRawField(x.Logger, packet[cursor:], "error-status")
 		if err != nil {
 			return nil, fmt.Errorf("Error parsing SNMP packet error: %s", err.Error())
 		}
 		cursor += count
 		if errorStatus, ok := rawError.(int); ok {
-			response.Error = uint8(errorStatus)
-			if LoggingDisabled != true {
-				slog.Printf("errorStatus: %d", uint8(errorStatus))
+			// error-status values are defined up to 18, so one that
+			// doesn't fit ErrorStatus is rejected rather than truncated
+			if errorStatus < 0 || errorStatus > math.MaxUint8 {
+				return nil, fmt.Errorf("Error parsing SNMP packet error: error-status %d out of range", errorStatus)
 			}
+			response.Error = ErrorStatus(errorStatus)
+			x.logPrintf("errorStatus: %s", response.Error)
 		}
 
 		// Parse Error-Index
-		rawErrorIndex, count, err := parseRawField(packet[cursor:], "error index")
+		rawErrorIndex, count, err := parseRawField(x.Logger, packet[cursor:], "error index")
 		if err != nil {
 			return nil, fmt.Errorf("Error parsing SNMP packet error index: %s", err.Error())
 		}
 		cursor += count
-		if errorindex, ok := rawErrorIndex.(int); ok {
-			response.ErrorIndex = uint8(errorindex)
-			if LoggingDisabled != true {
-				slog.Printf("error-index: %d", uint8(errorindex))
+		if errorindex, ok := rawErrorIndex.(int); ok && errorindex >= 0 {
+			response.ErrorIndex = uint32(errorindex)
+			x.logPrintf("error-index: %d", response.ErrorIndex)
+		}
+	}
+
+	return x.unmarshalVBL(packet[cursor:], response, length)
+}
+
+// unmarshal an SNMPv1 Trap-PDU - RFC 1157 4.1.6. The enterprise, agent-addr,
+// generic-trap, specific-trap and time-stamp fields replace the request id,
+// error-status and error-index of the other PDUs.
+func (x *GoSNMP) unmarshalTrapV1(packet []byte, response *SnmpPacket, length int) (*SnmpPacket, error) {
+	dumpBytes1(x.Logger, packet, "SNMP Packet is TRAP", 16)
+	response.PDUType = Trap
+
+	trapLength, cursor := parseLength(packet)
+	if len(packet) != trapLength {
+		return nil, fmt.Errorf("Error verifying Trap sanity: Got %d Expected: %d\n", len(packet), trapLength)
+	}
+
+	fields := []struct {
+		name string
+		typ  Asn1BER
+	}{
+		{"enterprise", ObjectIdentifier},
+		{"agent-addr", IPAddress},
+		{"generic-trap", Integer},
+		{"specific-trap", Integer},
+		{"time-stamp", TimeTicks},
+	}
+	for _, field := range fields {
+		if cursor >= len(packet) || Asn1BER(packet[cursor]) != field.typ {
+			return nil, fmt.Errorf("Error parsing SNMP trap %s: missing or wrong type", field.name)
+		}
+		fieldLength, _ := parseLength(packet[cursor:])
+		if cursor+fieldLength > len(packet) {
+			return nil, fmt.Errorf("Error parsing SNMP trap %s: truncated", field.name)
+		}
+		decoded, err := x.decodeValue(packet[cursor:cursor+fieldLength], field.name)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing SNMP trap %s: %s", field.name, err.Error())
+		}
+		cursor += fieldLength
+
+		switch field.name {
+		case "enterprise":
+			response.Enterprise, _ = decoded.Value.(string)
+		case "agent-addr":
+			response.AgentAddress, _ = decoded.Value.(string)
+		case "generic-trap":
+			response.GenericTrap, _ = decoded.Value.(int)
+		case "specific-trap":
+			response.SpecificTrap, _ = decoded.Value.(int)
+		case "time-stamp":
+			if timestamp, ok := decoded.Value.(int); ok {
+				response.Timestamp = uint(timestamp)
 			}
 		}
 	}
+	x.logPrintf("trap: enterprise %s agent %s generic %d specific %d timestamp %d",
+		response.Enterprise, response.AgentAddress, response.GenericTrap,
+		response.SpecificTrap, response.Timestamp)
 
-	return unmarshalVBL(packet[cursor:], response, length)
+	return x.unmarshalVBL(packet[cursor:], response, length)
 }
 
 // unmarshal a Varbind list
-func unmarshalVBL(packet []byte, response *SnmpPacket,
+func (x *GoSNMP) unmarshalVBL(packet []byte, response *SnmpPacket,
 	length int) (*SnmpPacket, error) {
 
-	dumpBytes1(packet, "\n=== unmarshalVBL()", 32)
+	dumpBytes1(x.Logger, packet, "\n=== unmarshalVBL()", 32)
 	var cursor, cursorInc int
 	var vblLength int
 	if packet[cursor] != 0x30 {
@@ -372,18 +635,20 @@ func unmarshalVBL(packet []byte, response *SnmpPacket,
 			packet[cursor])
 	}
 
+	if len(packet) == 2 && packet[1] == 0 {
+		// an empty list, eg of a tooBig response
+		return response, nil
+	}
 	vblLength, cursor = parseLength(packet)
 	if len(packet) != vblLength {
 		return nil, fmt.Errorf("Error verifying: packet length %d vbl length %d\n",
 			len(packet), vblLength)
 	}
-	if LoggingDisabled != true {
-		slog.Printf("vblLength: %d", vblLength)
-	}
+	x.logPrintf("vblLength: %d", vblLength)
 
 	// Loop & parse Varbinds
 	for cursor < vblLength {
-		dumpBytes1(packet[cursor:], fmt.Sprintf("\nSTARTING a varbind. Cursor %d", cursor), 32)
+		dumpBytes1(x.Logger, packet[cursor:], fmt.Sprintf("\nSTARTING a varbind. Cursor %d", cursor), 32)
 		if packet[cursor] != 0x30 {
 			return nil, fmt.Errorf("Expected a sequence when unmarshalling a VB, got %x", packet[cursor])
 		}
@@ -392,7 +657,7 @@ func unmarshalVBL(packet []byte, response *SnmpPacket,
 		cursor += cursorInc
 
 		// Parse OID
-		rawOid, oidLength, err := parseRawField(packet[cursor:], "OID")
+		rawOid, oidLength, err := parseRawField(x.Logger, packet[cursor:], "OID")
 		if err != nil {
 			return nil, fmt.Errorf("Error parsing OID Value: %s", err.Error())
 		}
@@ -404,18 +669,17 @@ func unmarshalVBL(packet []byte, response *SnmpPacket,
 			return nil, fmt.Errorf("unable to type assert rawOid |%v| to []int", rawOid)
 		}
 		oidStr := oidToString(oid)
-		if LoggingDisabled != true {
-			slog.Printf("OID: %s", oidStr)
-		}
+		x.logPrintf("OID: %s", oidStr)
 
 		// Parse Value
-		v, err := decodeValue(packet[cursor:], "value")
+		v, err := x.decodeValue(packet[cursor:], "value")
 		if err != nil {
 			return nil, fmt.Errorf("Error decoding value: %v", err)
 		}
 		valueLength, _ := parseLength(packet[cursor:])
 		cursor += valueLength
-		response.Variables = append(response.Variables, SnmpPDU{oidStr, v.Type, v.Value})
+		response.Variables = append(response.Variables,
+			SnmpPDU{Name: oidStr, Type: v.Type, Value: v.Value, decoded: newDecodedOID(oidStr, oid)})
 	}
 	return response, nil
 }
@@ -7,8 +7,9 @@ package gosnmp
 import (
 	"fmt"
 	"io/ioutil"
-	"log"
+	"net"
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -112,7 +113,7 @@ var testsEnmarshal = []testsEnmarshalT{
 // vbPosPdus returns a slice of oids in the given test
 func vbPosPdus(test testsEnmarshalT) (pdus []SnmpPDU) {
 	for _, vbp := range test.vbPositions {
-		pdu := SnmpPDU{vbp.oid, vbp.pduType, vbp.pduValue}
+		pdu := SnmpPDU{Name: vbp.oid, Type: vbp.pduType, Value: vbp.pduValue}
 		pdus = append(pdus, pdu)
 	}
 	return
@@ -147,13 +148,9 @@ func checkByteEquality(t *testing.T, test testsEnmarshalT, testBytes []byte,
 // ie check each varbind is working, then the varbind list, etc
 
 func TestEnmarshalVarbind(t *testing.T) {
-
-	// slog = log.New(os.Stdout, "", 0) // for verbose debugging
-	slog = log.New(ioutil.Discard, "", 0)
-
 	for _, test := range testsEnmarshal {
 		for j, test2 := range test.vbPositions {
-			snmppdu := &SnmpPDU{test2.oid, test2.pduType, test2.pduValue}
+			snmppdu := &SnmpPDU{Name: test2.oid, Type: test2.pduType, Value: test2.pduValue}
 			testBytes, err := marshalVarbind(snmppdu)
 			if err != nil {
 				t.Errorf("#%s:%d:%s err returned: %v",
@@ -166,10 +163,6 @@ func TestEnmarshalVarbind(t *testing.T) {
 }
 
 func TestEnmarshalVBL(t *testing.T) {
-
-	// slog = log.New(os.Stdout, "", 0) // for verbose debugging
-	slog = log.New(ioutil.Discard, "", 0)
-
 	for _, test := range testsEnmarshal {
 		x := &SnmpPacket{
 			Community: test.community,
@@ -189,10 +182,6 @@ func TestEnmarshalVBL(t *testing.T) {
 }
 
 func TestEnmarshalPDU(t *testing.T) {
-
-	// slog = log.New(os.Stdout, "", 0) // for verbose debugging
-	slog = log.New(ioutil.Discard, "", 0)
-
 	for _, test := range testsEnmarshal {
 		x := &SnmpPacket{
 			Community: test.community,
@@ -212,10 +201,6 @@ func TestEnmarshalPDU(t *testing.T) {
 }
 
 func TestEnmarshalMsg(t *testing.T) {
-
-	// slog = log.New(os.Stdout, "", 0) // for verbose debugging
-	slog = log.New(ioutil.Discard, "", 0)
-
 	for _, test := range testsEnmarshal {
 		x := &SnmpPacket{
 			Community: test.community,
@@ -234,6 +219,108 @@ func TestEnmarshalMsg(t *testing.T) {
 	}
 }
 
+var testsMarshalValue = []struct {
+	pdu      SnmpPDU
+	expected []byte
+}{
+	{SnmpPDU{Name: ".1", Type: Null, Value: nil}, []byte{0x05, 0x00}},
+	{SnmpPDU{Name: ".1", Type: Boolean, Value: true}, []byte{0x01, 0x01, 0xff}},
+	{SnmpPDU{Name: ".1", Type: Integer, Value: 2}, []byte{0x02, 0x01, 0x02}},
+	{SnmpPDU{Name: ".1", Type: Integer, Value: 300}, []byte{0x02, 0x02, 0x01, 0x2c}},
+	{SnmpPDU{Name: ".1", Type: Integer, Value: -1}, []byte{0x02, 0x01, 0xff}},
+	{SnmpPDU{Name: ".1", Type: Integer, Value: int32(-300)}, []byte{0x02, 0x02, 0xfe, 0xd4}},
+	{SnmpPDU{Name: ".1", Type: BitString, Value: BitStringValue{[]byte{0xa0}, 3}}, []byte{0x03, 0x02, 0x05, 0xa0}},
+	{SnmpPDU{Name: ".1", Type: OctetString, Value: "test"}, []byte{0x04, 0x04, 't', 'e', 's', 't'}},
+	{SnmpPDU{Name: ".1", Type: OctetString, Value: []byte{0x00, 0x1b}}, []byte{0x04, 0x02, 0x00, 0x1b}},
+	{SnmpPDU{Name: ".1", Type: ObjectIdentifier, Value: ".1.3.6.1.4.1.2680"}, []byte{0x06, 0x07, 0x2b, 0x06, 0x01, 0x04, 0x01, 0x94, 0x78}},
+	{SnmpPDU{Name: ".1", Type: ObjectIdentifier, Value: OID{1, 3, 6, 1, 4, 1, 2680}}, []byte{0x06, 0x07, 0x2b, 0x06, 0x01, 0x04, 0x01, 0x94, 0x78}},
+	{SnmpPDU{Name: ".1", Type: IPAddress, Value: "10.0.0.1"}, []byte{0x40, 0x04, 0x0a, 0x00, 0x00, 0x01}},
+	{SnmpPDU{Name: ".1", Type: Counter32, Value: uint(4294967295)}, []byte{0x41, 0x05, 0x00, 0xff, 0xff, 0xff, 0xff}},
+	{SnmpPDU{Name: ".1", Type: Gauge32, Value: 128}, []byte{0x42, 0x02, 0x00, 0x80}},
+	{SnmpPDU{Name: ".1", Type: TimeTicks, Value: uint32(65536)}, []byte{0x43, 0x03, 0x01, 0x00, 0x00}},
+	{SnmpPDU{Name: ".1", Type: Opaque, Value: []byte{0x9f, 0x78}}, []byte{0x44, 0x02, 0x9f, 0x78}},
+	{SnmpPDU{Name: ".1", Type: Counter64, Value: uint64(1) << 40}, []byte{0x46, 0x06, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	{SnmpPDU{Name: ".1", Type: Uinteger32, Value: 7}, []byte{0x47, 0x01, 0x07}},
+	{SnmpPDU{Name: ".1", Type: EndOfMibView, Value: nil}, []byte{0x82, 0x00}},
+}
+
+func TestMarshalValue(t *testing.T) {
+	for i, test := range testsMarshalValue {
+		testBytes, err := marshalValue(&test.pdu)
+		if err != nil {
+			t.Errorf("%d: %#x marshalValue() err returned: %v", i, test.pdu.Type, err)
+			continue
+		}
+		if !reflect.DeepEqual(testBytes, test.expected) {
+			t.Errorf("%d: %#x got |%x| expected |%x|", i, test.pdu.Type, testBytes, test.expected)
+		}
+	}
+}
+
+var testsMarshalValueErrors = []SnmpPDU{
+	{Name: ".1", Type: Boolean, Value: 1},
+	{Name: ".1", Type: Integer, Value: "1"},
+	{Name: ".1", Type: Integer, Value: int64(1) << 32},
+	{Name: ".1", Type: Counter32, Value: -1},
+	{Name: ".1", Type: Gauge32, Value: uint64(1) << 32},
+	{Name: ".1", Type: OctetString, Value: 1},
+	{Name: ".1", Type: ObjectIdentifier, Value: 1},
+	{Name: ".1", Type: IPAddress, Value: "not an address"},
+	{Name: ".1", Type: 0x30, Value: nil},
+}
+
+func TestMarshalValueErrors(t *testing.T) {
+	for i, pdu := range testsMarshalValueErrors {
+		if _, err := marshalValue(&pdu); err == nil {
+			t.Errorf("%d: %#x value %v: expected an error", i, pdu.Type, pdu.Value)
+		}
+	}
+}
+
+// a marshalled value is decoded back to the same value
+func TestMarshalValueRoundTrip(t *testing.T) {
+	x := &GoSNMP{}
+
+	tests := []struct {
+		pdu      SnmpPDU
+		expected interface{}
+	}{
+		{SnmpPDU{Name: ".1", Type: Boolean, Value: false}, false},
+		{SnmpPDU{Name: ".1", Type: Integer, Value: -2147483648}, -2147483648},
+		{SnmpPDU{Name: ".1", Type: BitString, Value: BitStringValue{[]byte{0xa0}, 3}}, BitStringValue{[]byte{0xa0}, 3}},
+		{SnmpPDU{Name: ".1", Type: OctetString, Value: "sysContact"}, OctetStringValue("sysContact")},
+		{SnmpPDU{Name: ".1", Type: OctetString, Value: []byte{0x00, 0x1b, 0x21}}, OctetStringValue{0x00, 0x1b, 0x21}},
+		{SnmpPDU{Name: ".1", Type: OctetString, Value: []byte{}}, OctetStringValue{}},
+		{SnmpPDU{Name: ".1", Type: OctetString, Value: OctetStringValue{0x00}}, OctetStringValue{0x00}},
+		{SnmpPDU{Name: ".1", Type: ObjectIdentifier, Value: ".1.3.6.1.2.1.1"}, ".1.3.6.1.2.1.1"},
+		{SnmpPDU{Name: ".1", Type: ObjectDescription, Value: "descr"}, []byte("descr")},
+		{SnmpPDU{Name: ".1", Type: IPAddress, Value: net.ParseIP("2001:db8::1")}, "2001:db8::1"},
+		{SnmpPDU{Name: ".1", Type: Counter32, Value: uint32(3000000000)}, uint(3000000000)},
+		{SnmpPDU{Name: ".1", Type: Gauge32, Value: 1000}, uint(1000)},
+		{SnmpPDU{Name: ".1", Type: TimeTicks, Value: 123456}, 123456},
+		{SnmpPDU{Name: ".1", Type: Opaque, Value: []byte{0x9f, 0x78, 0x04}}, []byte{0x9f, 0x78, 0x04}},
+		{SnmpPDU{Name: ".1", Type: NsapAddress, Value: []byte{0x49, 0x00}}, []byte{0x49, 0x00}},
+		{SnmpPDU{Name: ".1", Type: Counter64, Value: uint64(1) << 40}, int64(1) << 40},
+		{SnmpPDU{Name: ".1", Type: Uinteger32, Value: uint(4294967295)}, uint(4294967295)},
+	}
+	for i, test := range tests {
+		testBytes, err := marshalValue(&test.pdu)
+		if err != nil {
+			t.Errorf("%d: %#x marshalValue() err returned: %v", i, test.pdu.Type, err)
+			continue
+		}
+		decoded, err := x.decodeValue(testBytes, "test")
+		if err != nil {
+			t.Errorf("%d: %#x decodeValue() err returned: %v", i, test.pdu.Type, err)
+			continue
+		}
+		if decoded.Type != test.pdu.Type || !reflect.DeepEqual(decoded.Value, test.expected) {
+			t.Errorf("%d: got %#x %#v expected %#x %#v",
+				i, decoded.Type, decoded.Value, test.pdu.Type, test.expected)
+		}
+	}
+}
+
 // -- Unmarshal -----------------------------------------------------------------
 
 var testsUnmarshal = []struct {
@@ -267,7 +354,7 @@ var testsUnmarshal = []struct {
 				{
 					Name:  ".1.3.6.1.2.1.1.4.0",
 					Type:  OctetString,
-					Value: "Administrator",
+					Value: OctetStringValue("Administrator"),
 				},
 				{
 					Name:  ".1.3.6.1.2.1.43.5.1.1.15.1",
@@ -282,7 +369,7 @@ var testsUnmarshal = []struct {
 				{
 					Name:  ".1.3.6.1.4.1.23.2.5.1.1.1.4.2",
 					Type:  OctetString,
-					Value: "00 15 99 37 76 2b",
+					Value: OctetStringValue{0x00, 0x15, 0x99, 0x37, 0x76, 0x2b},
 				},
 				{
 					Name:  ".1.3.6.1.2.1.1.3.0",
@@ -309,7 +396,7 @@ var testsUnmarshal = []struct {
 				{
 					Name:  ".1.3.6.1.2.1.2.2.1.2.6",
 					Type:  OctetString,
-					Value: "GigabitEthernet0",
+					Value: OctetStringValue("GigabitEthernet0"),
 				},
 				{
 					Name:  ".1.3.6.1.2.1.2.2.1.5.3",
@@ -329,7 +416,7 @@ var testsUnmarshal = []struct {
 				{
 					Name:  ".1.3.6.1.2.1.3.1.1.2.10.1.10.11.0.17",
 					Type:  OctetString,
-					Value: "00 07 7d 4d 09 00",
+					Value: OctetStringValue{0x00, 0x07, 0x7d, 0x4d, 0x09, 0x00},
 				},
 				{
 					Name:  ".1.3.6.1.2.1.3.1.1.3.10.1.10.11.0.2",
@@ -410,7 +497,7 @@ var testsUnmarshal = []struct {
 				{
 					Name:  ".1.3.6.1.2.1.1.9.1.3.3",
 					Type:  OctetString,
-					Value: "The MIB module for managing IP and ICMP implementations",
+					Value: OctetStringValue("The MIB module for managing IP and ICMP implementations"),
 				},
 				{
 					Name:  ".1.3.6.1.2.1.1.9.1.4.2",
@@ -496,15 +583,15 @@ var testsUnmarshal = []struct {
 
 func TestUnmarshal(t *testing.T) {
 
-	// slog = log.New(os.Stdout, "", 0) // for verbose debugging
-	slog = log.New(ioutil.Discard, "", 0)
+	x := &GoSNMP{}
+	// x.Logger = log.New(os.Stdout, "", 0) // for verbose debugging
 
 SANITY:
 	for i, test := range testsUnmarshal {
 		var err error
 		var res *SnmpPacket
 
-		if res, err = unmarshal(test.in()); err != nil {
+		if res, err = x.unmarshal(test.in()); err != nil {
 			t.Errorf("#%d, Unmarshal returned err: %v", i, err)
 			continue SANITY
 		} else if res == nil {
@@ -554,7 +641,11 @@ SANITY:
 				if vbval.Cmp(vbrval) != 0 {
 					t.Errorf("#%d:%d Value result: %v, test: %v", i, n, vbr.Value, vb.Value)
 				}
-			case OctetString, IPAddress, ObjectIdentifier:
+			case OctetString:
+				if !reflect.DeepEqual(vb.Value, vbr.Value) {
+					t.Errorf("#%d:%d Value result: %v, test: %v", i, n, vbr.Value, vb.Value)
+				}
+			case IPAddress, ObjectIdentifier:
 				if vb.Value != vbr.Value {
 					t.Errorf("#%d:%d Value result: %v, test: %v", i, n, vbr.Value, vb.Value)
 				}
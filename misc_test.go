@@ -22,6 +22,8 @@ var testsMarshalLength = []struct {
 }{
 	{1, []byte{0x01}},
 	{129, []byte{0x81, 0x81}},
+	{256, []byte{0x82, 0x01, 0x00}},
+	{0x1234, []byte{0x82, 0x12, 0x34}},
 }
 
 func TestMarshalLength(t *testing.T) {
@@ -39,6 +41,84 @@ func TestMarshalLength(t *testing.T) {
 
 // -----------------------------------------------------------------------------
 
+var testsMarshalInt64 = []struct {
+	value    int64
+	expected []byte
+}{
+	{0, []byte{0x00}},
+	{127, []byte{0x7f}},
+	{128, []byte{0x00, 0x80}},
+	{256, []byte{0x01, 0x00}},
+	{-1, []byte{0xff}},
+	{-128, []byte{0x80}},
+	{-129, []byte{0xff, 0x7f}},
+	{-32768, []byte{0x80, 0x00}},
+	{2147483647, []byte{0x7f, 0xff, 0xff, 0xff}},
+	{-2147483648, []byte{0x80, 0x00, 0x00, 0x00}},
+	{-9223372036854775808, []byte{0x80, 0, 0, 0, 0, 0, 0, 0}},
+}
+
+func TestMarshalInt64(t *testing.T) {
+	for i, test := range testsMarshalInt64 {
+		testBytes := marshalInt64(test.value)
+		if !reflect.DeepEqual(testBytes, test.expected) {
+			t.Errorf("%d: value %d got |%x| expected |%x|",
+				i, test.value, testBytes, test.expected)
+		}
+		if parsed, err := parseInt64(testBytes); err != nil || parsed != test.value {
+			t.Errorf("%d: value %d parsed back as %d, err %v", i, test.value, parsed, err)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var testsMarshalUint32 = []struct {
+	value    uint32
+	expected []byte
+}{
+	{0, []byte{0x00}},
+	{127, []byte{0x7f}},
+	{128, []byte{0x00, 0x80}},
+	{256, []byte{0x01, 0x00}},
+	{0x7fffffff, []byte{0x7f, 0xff, 0xff, 0xff}},
+	{0xffffffff, []byte{0x00, 0xff, 0xff, 0xff, 0xff}},
+}
+
+func TestMarshalUint32(t *testing.T) {
+	for i, test := range testsMarshalUint32 {
+		testBytes := marshalUint32(test.value)
+		if !reflect.DeepEqual(testBytes, test.expected) {
+			t.Errorf("%d: value %d got |%x| expected |%x|",
+				i, test.value, testBytes, test.expected)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+var testsMarshalUint64 = []struct {
+	value    uint64
+	expected []byte
+}{
+	{0, []byte{0x00}},
+	{0xffffffff, []byte{0x00, 0xff, 0xff, 0xff, 0xff}},
+	{0x7fffffffffffffff, []byte{0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+	{0xffffffffffffffff, []byte{0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+}
+
+func TestMarshalUint64(t *testing.T) {
+	for i, test := range testsMarshalUint64 {
+		testBytes := marshalUint64(test.value)
+		if !reflect.DeepEqual(testBytes, test.expected) {
+			t.Errorf("%d: value %d got |%x| expected |%x|",
+				i, test.value, testBytes, test.expected)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+
 var testsPartition = []struct {
 	currentPosition int
 	partitionSize   int
@@ -79,6 +159,7 @@ var testsSnmpVersionString = []struct {
 }{
 	{Version1, "1"},
 	{Version2c, "2c"},
+	{Version3, "3"},
 }
 
 func TestSnmpVersionString(t *testing.T) {
@@ -91,3 +172,32 @@ func TestSnmpVersionString(t *testing.T) {
 }
 
 // ---------------------------------------------------------------------
+
+var testsOctetStringValue = []struct {
+	in   OctetStringValue
+	text string
+	hex  string
+	mac  string
+}{
+	{OctetStringValue("eth0"), "eth0", "65 74 68 30", "65:74:68:30"},
+	{OctetStringValue{0x00, 0x15, 0x99, 0x37, 0x76, 0x2b}, "\x00\x15\x997v+",
+		"00 15 99 37 76 2b", "00:15:99:37:76:2b"},
+	{OctetStringValue{0x00}, "\x00", "00", "00"},
+	{OctetStringValue{}, "", "", ""},
+}
+
+func TestOctetStringValue(t *testing.T) {
+	for i, test := range testsOctetStringValue {
+		if result := test.in.String(); result != test.text {
+			t.Errorf("#%d, String() got %q expected %q", i, result, test.text)
+		}
+		if result := test.in.Hex(); result != test.hex {
+			t.Errorf("#%d, Hex() got %q expected %q", i, result, test.hex)
+		}
+		if result := test.in.MAC(); result != test.mac {
+			t.Errorf("#%d, MAC() got %q expected %q", i, result, test.mac)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------
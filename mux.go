@@ -0,0 +1,266 @@
+// Copyright 2012-2014 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// UDPMux shares a few unconnected UDP sockets between many targets, rather
+// than each GoSNMP dialling its own. Each target is given a Transport by
+// Transport(); received messages are passed to the Transports of their source
+// address, and then matched to requests by the GoSNMP using it. Each target's
+// GoSNMP keeps its own Community, Version, Timeout etc:
+//
+//     mux, err := NewUDPMux(4)
+//     ...
+//     x := &GoSNMP{Target: "192.0.2.1", Port: 161, Community: "public",
+//         Version: Version2c, Timeout: 2 * time.Second}
+//     if x.Transport, err = mux.Transport(x.Target, x.Port); err != nil { ... }
+//     err = x.Connect()
+type UDPMux struct {
+	conns []net.PacketConn
+
+	mu      sync.Mutex
+	next    int                        // next is the socket for the next Transport
+	targets map[string][]*muxTransport // targets are the open Transports, by address
+	closed  bool
+}
+
+// muxInboxSize is the number of messages queued for each Transport of a
+// UDPMux; further messages are dropped until they're received
+const muxInboxSize = 64
+
+// the delay between reads of a socket of a UDPMux after an error doubles
+// from minMuxBackoff up to maxMuxBackoff, so a failing socket can't spin
+const (
+	minMuxBackoff = 5 * time.Millisecond
+	maxMuxBackoff = time.Second
+)
+
+// errMuxTransportClosed is returned by a Transport of a UDPMux once closed
+var errMuxTransportClosed = errors.New("UDPMux transport is closed")
+
+// NewUDPMux opens the given number of UDP sockets (at least one), on
+// ephemeral ports
+func NewUDPMux(sockets int) (*UDPMux, error) {
+	if sockets < 1 {
+		sockets = 1
+	}
+	conns := make([]net.PacketConn, 0, sockets)
+	for i := 0; i < sockets; i++ {
+		conn, err := net.ListenPacket("udp", ":0")
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, fmt.Errorf("Unable to open UDP socket: %s", err.Error())
+		}
+		conns = append(conns, conn)
+	}
+	return NewUDPMuxConn(conns...), nil
+}
+
+// NewUDPMuxConn returns a UDPMux sharing the given unconnected sockets,
+// which it then owns
+func NewUDPMuxConn(conns ...net.PacketConn) *UDPMux {
+	m := &UDPMux{conns: conns, targets: make(map[string][]*muxTransport)}
+	for _, conn := range conns {
+		go m.read(conn)
+	}
+	return m
+}
+
+// Transport returns a Transport to target (a host name or address) and
+// port, for use as GoSNMP.Transport. Targets are spread across the sockets.
+func (m *UDPMux) Transport(target string, port uint16) (Transport, error) {
+	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", target, port))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to resolve target %s: %s", target, err.Error())
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, fmt.Errorf("UDPMux is closed")
+	}
+	t := &muxTransport{
+		mux:    m,
+		conn:   m.conns[m.next%len(m.conns)],
+		addr:   addr,
+		key:    addr.String(),
+		in:     make(chan muxMessage, muxInboxSize),
+		closed: make(chan struct{}),
+	}
+	m.next++
+	m.targets[t.key] = append(m.targets[t.key], t)
+	return t, nil
+}
+
+// Close closes the sockets, and every Transport
+func (m *UDPMux) Close() error {
+	m.mu.Lock()
+	m.closed = true
+	var transports []*muxTransport
+	for _, ts := range m.targets {
+		transports = append(transports, ts...)
+	}
+	m.mu.Unlock()
+
+	for _, t := range transports {
+		t.close(errMuxTransportClosed)
+	}
+	var err error
+	for _, conn := range m.conns {
+		if e := conn.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// read passes each message received on conn to the Transports of its source
+// address, until conn is closed. Messages from unknown addresses are dropped.
+// After other errors (eg ICMP unreachables) it backs off before reading again.
+// If conn is closed other than by Close, the Transports using it are closed
+// with the error, failing their pending requests.
+func (m *UDPMux) read(conn net.PacketConn) {
+	buf := make([]byte, rxBufSize+1)
+	backoff := time.Duration(0)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			m.mu.Lock()
+			closed := m.closed
+			m.mu.Unlock()
+			if closed {
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				m.fail(conn, fmt.Errorf("UDPMux socket failed: %w", err))
+				return
+			}
+			if backoff = 2 * backoff; backoff < minMuxBackoff {
+				backoff = minMuxBackoff
+			} else if backoff > maxMuxBackoff {
+				backoff = maxMuxBackoff
+			}
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+		m.mu.Lock()
+		for _, t := range m.targets[addr.String()] {
+			msg, err := received(buf, n)
+			select {
+			case t.in <- muxMessage{msg, err}:
+			default:
+				// the target isn't keeping up - drop, as UDP would
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+// fail closes the Transports using conn with err
+func (m *UDPMux) fail(conn net.PacketConn, err error) {
+	m.mu.Lock()
+	var transports []*muxTransport
+	for _, ts := range m.targets {
+		for _, t := range ts {
+			if t.conn == conn {
+				transports = append(transports, t)
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	for _, t := range transports {
+		t.close(err)
+	}
+}
+
+// remove deregisters a closed Transport
+func (m *UDPMux) remove(t *muxTransport) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ts := m.targets[t.key]
+	for i := range ts {
+		if ts[i] == t {
+			ts = append(ts[:i], ts[i+1:]...)
+			break
+		}
+	}
+	if len(ts) == 0 {
+		delete(m.targets, t.key)
+	} else {
+		m.targets[t.key] = ts
+	}
+}
+
+// muxMessage is a message received for a muxTransport, with the error
+// if it's truncated
+type muxMessage struct {
+	msg []byte
+	err error
+}
+
+// muxTransport is the Transport to one target of a UDPMux
+type muxTransport struct {
+	mux    *UDPMux
+	conn   net.PacketConn
+	addr   *net.UDPAddr
+	key    string
+	in     chan muxMessage
+	once   sync.Once
+	closed chan struct{}
+	err    error // err is returned once closed is closed
+}
+
+func (t *muxTransport) Send(msg []byte) error {
+	select {
+	case <-t.closed:
+		return t.err
+	default:
+	}
+	_, err := t.conn.WriteTo(msg, t.addr)
+	return err
+}
+
+func (t *muxTransport) Receive(deadline time.Time) ([]byte, error) {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case m := <-t.in:
+		return m.msg, m.err
+	case <-t.closed:
+		return nil, t.err
+	case <-timeout:
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+// Close deregisters the target; the shared sockets stay open
+func (t *muxTransport) Close() error {
+	t.close(errMuxTransportClosed)
+	return nil
+}
+
+// close deregisters the target, failing later calls with err
+func (t *muxTransport) close(err error) {
+	t.once.Do(func() {
+		t.err = err
+		close(t.closed)
+		t.mux.remove(t)
+	})
+}
@@ -0,0 +1,212 @@
+// Copyright 2012-2014 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// namedAgent answers each request on conn with its name and the request's
+// community, as the value of each varbind
+func namedAgent(t *testing.T, conn *net.UDPConn, name string) {
+	buf := make([]byte, rxBufSize)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		request, err := new(GoSNMP).unmarshal(buf[:n])
+		if err != nil {
+			t.Errorf("agent: unable to decode request: %v", err)
+			return
+		}
+		response := &SnmpPacket{Version: request.Version, Community: request.Community, PDUType: GetResponse}
+		var pdus []SnmpPDU
+		for _, v := range request.Variables {
+			pdus = append(pdus, SnmpPDU{Name: v.Name, Type: OctetString, Value: name + "/" + request.Community})
+		}
+		msg, err := response.marshalMsg(pdus, response.PDUType, request.RequestID)
+		if err != nil {
+			t.Errorf("agent: unable to marshal reply: %v", err)
+			return
+		}
+		conn.WriteToUDP(msg, addr)
+	}
+}
+
+func TestUDPMux(t *testing.T) {
+	mux, err := NewUDPMux(2)
+	if err != nil {
+		t.Fatalf("NewUDPMux() err returned: %v", err)
+	}
+	defer mux.Close()
+
+	// two communities on each of three agents, sharing two sockets
+	var clients []*GoSNMP
+	for i := 0; i < 3; i++ {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatalf("unable to listen: %v", err)
+		}
+		defer conn.Close()
+		go namedAgent(t, conn, fmt.Sprintf("agent%d", i))
+
+		for _, community := range []string{"public", "private"} {
+			x := &GoSNMP{
+				Target:    "127.0.0.1",
+				Port:      uint16(conn.LocalAddr().(*net.UDPAddr).Port),
+				Community: community,
+				Version:   Version2c,
+				Timeout:   time.Duration(2) * time.Second,
+				Retries:   1,
+			}
+			if x.Transport, err = mux.Transport(x.Target, x.Port); err != nil {
+				t.Fatalf("Transport() err returned: %v", err)
+			}
+			if err = x.Connect(); err != nil {
+				t.Fatalf("Connect() err returned: %v", err)
+			}
+			clients = append(clients, x)
+		}
+	}
+
+	errs := make(chan error, len(clients)*10)
+	for i, x := range clients {
+		expected := fmt.Sprintf("agent%d/%s", i/2, x.Community)
+		for j := 0; j < 10; j++ {
+			go func(x *GoSNMP) {
+				result, err := x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+				switch {
+				case err != nil:
+					errs <- err
+				case fmt.Sprint(result.Variables[0].Value) != expected:
+					errs <- fmt.Errorf("Get() got %v, expected %s", result.Variables[0].Value, expected)
+				default:
+					errs <- nil
+				}
+			}(x)
+		}
+	}
+	for i := 0; i < len(clients)*10; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestUDPMuxClose(t *testing.T) {
+	mux, err := NewUDPMux(1)
+	if err != nil {
+		t.Fatalf("NewUDPMux() err returned: %v", err)
+	}
+	transport, err := mux.Transport("127.0.0.1", 161)
+	if err != nil {
+		t.Fatalf("Transport() err returned: %v", err)
+	}
+	mux.Close()
+
+	if err = transport.Send([]byte{0x30, 0x00}); err == nil {
+		t.Errorf("Send() on a closed UDPMux expected an error")
+	}
+	if _, err = transport.Receive(time.Time{}); err == nil {
+		t.Errorf("Receive() on a closed UDPMux expected an error")
+	}
+	if _, err = mux.Transport("127.0.0.1", 161); err == nil {
+		t.Errorf("Transport() on a closed UDPMux expected an error")
+	}
+}
+
+// failingPacketConn fails every read with err until closed
+type failingPacketConn struct {
+	err    error
+	reads  int32
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (c *failingPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	atomic.AddInt32(&c.reads, 1)
+	select {
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	default:
+		return 0, nil, c.err
+	}
+}
+
+func (c *failingPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) { return len(b), nil }
+func (c *failingPacketConn) LocalAddr() net.Addr                          { return &net.UDPAddr{} }
+func (c *failingPacketConn) SetDeadline(t time.Time) error                { return nil }
+func (c *failingPacketConn) SetReadDeadline(t time.Time) error            { return nil }
+func (c *failingPacketConn) SetWriteDeadline(t time.Time) error           { return nil }
+
+func (c *failingPacketConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func TestUDPMuxReadErrors(t *testing.T) {
+	conn := &failingPacketConn{err: syscall.ECONNREFUSED, closed: make(chan struct{})}
+	mux := NewUDPMuxConn(conn)
+	time.Sleep(100 * time.Millisecond)
+	mux.Close()
+
+	// backing off from 5ms, there's time for about five reads
+	if reads := atomic.LoadInt32(&conn.reads); reads > 10 {
+		t.Errorf("UDPMux read a failing socket %d times in 100ms", reads)
+	}
+}
+
+func TestUDPMuxClosedPeer(t *testing.T) {
+	silent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer silent.Close()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	mux := NewUDPMuxConn(conn)
+	defer mux.Close()
+
+	x := &GoSNMP{
+		Target:    "127.0.0.1",
+		Port:      uint16(silent.LocalAddr().(*net.UDPAddr).Port),
+		Community: "public",
+		Version:   Version2c,
+		Timeout:   time.Duration(5) * time.Second,
+		Retries:   1,
+	}
+	if x.Transport, err = mux.Transport(x.Target, x.Port); err != nil {
+		t.Fatalf("Transport() err returned: %v", err)
+	}
+	if err = x.Connect(); err != nil {
+		t.Fatalf("Connect() err returned: %v", err)
+	}
+	errch := make(chan error, 1)
+	go func() {
+		_, err := x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+		errch <- err
+	}()
+
+	// closing the socket under the UDPMux fails the pending request
+	time.Sleep(100 * time.Millisecond)
+	conn.Close()
+	select {
+	case err = <-errch:
+		if err == nil {
+			t.Errorf("Get() on a closed socket expected an error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("Get() on a closed socket didn't return")
+	}
+}
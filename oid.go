@@ -0,0 +1,267 @@
+// Copyright 2012-2014 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// OID is an object identifier as its sub-identifiers, eg {1, 3, 6, 1, 2, 1}.
+// OIDs compare numerically, sub-identifier by sub-identifier, so .1.3.6.1.2
+// sorts before .1.3.6.1.10 unlike their strings.
+type OID []uint32
+
+// ParseOID parses an OID in dotted string format eg ".1.3.6.1.2.1"; the
+// leading dot is optional
+func ParseOID(s string) (OID, error) {
+	str := strings.TrimPrefix(s, ".")
+	if str == "" {
+		return nil, fmt.Errorf("Unable to parse an empty OID")
+	}
+	oid := make(OID, 0, strings.Count(str, ".")+1)
+	var n uint64
+	digits := 0
+	for i := 0; i <= len(str); i++ {
+		if i == len(str) || str[i] == '.' {
+			if digits == 0 {
+				return nil, fmt.Errorf("Unable to parse OID %q: empty sub-identifier", s)
+			}
+			oid = append(oid, uint32(n))
+			n, digits = 0, 0
+			continue
+		}
+		c := str[i]
+		if c < '0' || c > '9' {
+			return nil, fmt.Errorf("Unable to parse OID %q: bad character %q", s, c)
+		}
+		n = n*10 + uint64(c-'0')
+		digits++
+		if n > math.MaxUint32 {
+			return nil, fmt.Errorf("Unable to parse OID %q: sub-identifier out of range", s)
+		}
+	}
+	return oid, nil
+}
+
+// MustParseOID is ParseOID, panicking if s isn't a valid OID. It simplifies
+// initialising variables eg var ifDescr = MustParseOID(".1.3.6.1.2.1.2.2.1.2")
+func MustParseOID(s string) OID {
+	oid, err := ParseOID(s)
+	if err != nil {
+		panic(err)
+	}
+	return oid
+}
+
+// String returns the OID in dotted string format, with a leading dot
+func (o OID) String() string {
+	var b strings.Builder
+	for _, n := range o {
+		b.WriteByte('.')
+		b.WriteString(strconv.FormatUint(uint64(n), 10))
+	}
+	return b.String()
+}
+
+// Compare returns -1, 0 or 1 as o sorts before, equal to or after other in
+// lexicographic MIB order, in which an OID sorts before the OIDs below it
+func (o OID) Compare(other OID) int {
+	for i := 0; i < len(o) && i < len(other); i++ {
+		switch {
+		case o[i] < other[i]:
+			return -1
+		case o[i] > other[i]:
+			return 1
+		}
+	}
+	switch {
+	case len(o) < len(other):
+		return -1
+	case len(o) > len(other):
+		return 1
+	}
+	return 0
+}
+
+// IsDescendantOf reports whether o is below ancestor in the OID tree. An OID
+// isn't a descendant of itself.
+func (o OID) IsDescendantOf(ancestor OID) bool {
+	if len(o) <= len(ancestor) {
+		return false
+	}
+	for i, n := range ancestor {
+		if o[i] != n {
+			return false
+		}
+	}
+	return true
+}
+
+// Less reports whether o sorts before other, as for Compare
+func (o OID) Less(other OID) bool {
+	return o.Compare(other) < 0
+}
+
+// Equal reports whether o and other have the same sub-identifiers
+func (o OID) Equal(other OID) bool {
+	return len(o) == len(other) && o.HasPrefix(other)
+}
+
+// HasPrefix reports whether o is prefix, or below it
+func (o OID) HasPrefix(prefix OID) bool {
+	if len(o) < len(prefix) {
+		return false
+	}
+	for i, n := range prefix {
+		if o[i] != n {
+			return false
+		}
+	}
+	return true
+}
+
+// Parent returns the OID above o, or nil if o has no sub-identifiers
+func (o OID) Parent() OID {
+	if len(o) == 0 {
+		return nil
+	}
+	// limit the capacity, so appending to the parent copies it
+	return o[: len(o)-1 : len(o)-1]
+}
+
+// Append returns a new OID of o followed by subIDs, leaving o unchanged eg
+// a table column followed by a row index
+func (o OID) Append(subIDs ...uint32) OID {
+	oid := make(OID, 0, len(o)+len(subIDs))
+	oid = append(oid, o...)
+	return append(oid, subIDs...)
+}
+
+// Index returns the sub-identifiers of o below prefix eg the index of a table
+// row, from the OID of one of its cells and the OID of the column. ok is false
+// if o isn't below prefix.
+func (o OID) Index(prefix OID) (index OID, ok bool) {
+	if !o.IsDescendantOf(prefix) {
+		return nil, false
+	}
+	return o[len(prefix):], true
+}
+
+// Marshal returns the BER encoding of o: the tag, length and sub-identifiers
+func (o OID) Marshal() ([]byte, error) {
+	content, err := o.marshalContent()
+	if err != nil {
+		return nil, err
+	}
+	return marshalTLV(byte(ObjectIdentifier), content)
+}
+
+// marshalContent returns the BER encoding of the sub-identifiers of o
+func (o OID) marshalContent() ([]byte, error) {
+	subIDs := make([]int, len(o))
+	for i, n := range o {
+		subIDs[i] = int(n)
+	}
+	content, err := marshalObjectIdentifier(subIDs)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to marshal OID %s: %s", o, err)
+	}
+	return content, nil
+}
+
+// Unmarshal sets o from the BER encoding of an OID, as returned by Marshal.
+// data must hold exactly one encoded OID.
+func (o *OID) Unmarshal(data []byte) error {
+	if len(data) < 3 || data[0] != byte(ObjectIdentifier) {
+		return fmt.Errorf("Unable to unmarshal OID: not an OBJECT IDENTIFIER")
+	}
+	// the length is one octet, or up to 4 octets after 0x80 | their count
+	length, cursor := int(data[1]), 2
+	if length > 127 {
+		count := length & 127
+		if count == 0 || count > 4 || cursor+count > len(data) {
+			return fmt.Errorf("Unable to unmarshal OID: invalid length")
+		}
+		length = 0
+		for _, b := range data[cursor : cursor+count] {
+			length = length<<8 | int(b)
+		}
+		cursor += count
+	}
+	switch {
+	case length > len(data)-cursor:
+		return fmt.Errorf("Unable to unmarshal OID: truncated at %d of %d octets", len(data)-cursor, length)
+	case length < len(data)-cursor:
+		return fmt.Errorf("Unable to unmarshal OID: %d octets after the OID", len(data)-cursor-length)
+	}
+	subIDs, err := parseObjectIdentifier(data[cursor:])
+	if err != nil {
+		return fmt.Errorf("Unable to unmarshal OID: %s", err)
+	}
+	oid := make(OID, len(subIDs))
+	for i, n := range subIDs {
+		if n < 0 || n > math.MaxUint32 {
+			return fmt.Errorf("Unable to unmarshal OID: sub-identifier %d out of range", n)
+		}
+		oid[i] = uint32(n)
+	}
+	*o = oid
+	return nil
+}
+
+// oidStrings returns the dotted strings of oids
+func oidStrings(oids []OID) []string {
+	names := make([]string, len(oids))
+	for i, oid := range oids {
+		names[i] = oid.String()
+	}
+	return names
+}
+
+// decodedOID is the OID of a varbind as decoded from a message, and the
+// Name formatted from it
+type decodedOID struct {
+	name string
+	oid  OID
+}
+
+// newDecodedOID returns the decodedOID of sub-identifiers decoded as name,
+// or nil if they don't fit an OID
+func newDecodedOID(name string, subIDs []int) *decodedOID {
+	oid := make(OID, len(subIDs))
+	for i, n := range subIDs {
+		if n < 0 || n > math.MaxUint32 {
+			return nil
+		}
+		oid[i] = uint32(n)
+	}
+	return &decodedOID{name, oid}
+}
+
+// OID returns the Name of pdu as an OID. For a varbind of a response, it's
+// the OID decoded from the message (unless Name has since been changed), so
+// Name isn't parsed again; it's shared by every copy of pdu, and mustn't be
+// modified.
+func (pdu SnmpPDU) OID() (OID, error) {
+	if pdu.decoded != nil && pdu.decoded.name == pdu.Name {
+		return pdu.decoded.oid, nil
+	}
+	return ParseOID(pdu.Name)
+}
+
+// Index returns the sub-identifiers of the Name of pdu below prefix eg the
+// index of the table row a cell belongs to. ok is false if the Name isn't
+// below prefix. Like OID, it doesn't parse the Name of a varbind of a
+// response, and the index returned then mustn't be modified.
+func (pdu SnmpPDU) Index(prefix OID) (index OID, ok bool) {
+	oid, err := pdu.OID()
+	if err != nil {
+		return nil, false
+	}
+	return oid.Index(prefix)
+}
@@ -0,0 +1,218 @@
+// Copyright 2012-2014 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testsParseOID = []struct {
+	in  string
+	oid OID
+	ok  bool
+}{
+	{".1.3.6.1.2.1", OID{1, 3, 6, 1, 2, 1}, true},
+	{"1.3.6.1.2.1", OID{1, 3, 6, 1, 2, 1}, true},
+	{".1.3.6.1.4.1.2021.4294967295", OID{1, 3, 6, 1, 4, 1, 2021, 4294967295}, true},
+	{".1.3.6.1.4.1.2021.4294967296", nil, false},
+	{"", nil, false},
+	{".", nil, false},
+	{".1.3..6", nil, false},
+	{".1.3.6.", nil, false},
+	{".1.3.-6", nil, false},
+	{".1.3.x", nil, false},
+}
+
+func TestParseOID(t *testing.T) {
+	for i, test := range testsParseOID {
+		oid, err := ParseOID(test.in)
+		if (err == nil) != test.ok {
+			t.Errorf("#%d: ParseOID(%q) got err %v", i, test.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(oid, test.oid) {
+			t.Errorf("#%d: ParseOID(%q) got %v, expected %v", i, test.in, oid, test.oid)
+		}
+		if test.ok && oid.String() != "."+strings.TrimPrefix(test.in, ".") {
+			t.Errorf("#%d: String() got %s for %q", i, oid, test.in)
+		}
+	}
+}
+
+var testsOIDCompare = []struct {
+	a, b       OID
+	compare    int
+	descendant bool // a is below b
+}{
+	{OID{1, 3, 6, 1}, OID{1, 3, 6, 1}, 0, false},
+	{OID{1, 3, 6, 1, 2}, OID{1, 3, 6, 1, 10}, -1, false},
+	{OID{1, 3, 6, 1, 10}, OID{1, 3, 6, 1, 2}, 1, false},
+	{OID{1, 3, 6, 1, 2, 2, 1, 10, 1}, OID{1, 3, 6, 1, 2, 2, 1, 1}, 1, false},
+	{OID{1, 3, 6, 1, 2, 2, 1, 1, 10}, OID{1, 3, 6, 1, 2, 2, 1, 1}, 1, true},
+	{OID{1, 3, 6, 1}, OID{1, 3, 6, 1, 0}, -1, false},
+	{OID{1, 3, 6, 1, 0}, OID{1, 3, 6, 1}, 1, true},
+	{OID{1, 3, 6, 1, 4294967295}, OID{1, 3, 6, 2}, -1, false},
+}
+
+func TestOIDCompare(t *testing.T) {
+	for i, test := range testsOIDCompare {
+		if got := test.a.Compare(test.b); got != test.compare {
+			t.Errorf("#%d: %s.Compare(%s) got %d, expected %d", i, test.a, test.b, got, test.compare)
+		}
+		if got := test.a.IsDescendantOf(test.b); got != test.descendant {
+			t.Errorf("#%d: %s.IsDescendantOf(%s) got %t", i, test.a, test.b, got)
+		}
+	}
+}
+
+func TestOIDManipulation(t *testing.T) {
+	ifDescr := MustParseOID(".1.3.6.1.2.1.2.2.1.2")
+	cell := ifDescr.Append(7)
+	if cell.String() != ".1.3.6.1.2.1.2.2.1.2.7" || len(ifDescr) != 10 {
+		t.Errorf("Append() got %s, leaving %s", cell, ifDescr)
+	}
+	if !cell.Parent().Equal(ifDescr) || cell.Equal(ifDescr) || !ifDescr.Less(cell) {
+		t.Errorf("Parent(), Equal() or Less() of %s and %s failed", cell, ifDescr)
+	}
+	if !cell.HasPrefix(ifDescr) || !cell.HasPrefix(cell) || ifDescr.HasPrefix(cell) {
+		t.Errorf("HasPrefix() of %s and %s failed", cell, ifDescr)
+	}
+	// appending to a parent mustn't change the child
+	if sibling := cell.Parent().Append(8); cell[10] != 7 || sibling[10] != 8 {
+		t.Errorf("Parent().Append() got %s, changing %s", sibling, cell)
+	}
+	if index, ok := cell.Index(ifDescr); !ok || !index.Equal(OID{7}) {
+		t.Errorf("Index() got %s %t, expected .7", index, ok)
+	}
+	if _, ok := ifDescr.Index(ifDescr); ok {
+		t.Errorf("Index() of an OID below itself got ok")
+	}
+	pdu := SnmpPDU{Name: ".1.3.6.1.2.1.2.2.1.2.10.1.2", Type: OctetString, Value: "eth0"}
+	if index, ok := pdu.Index(ifDescr); !ok || index.String() != ".10.1.2" {
+		t.Errorf("SnmpPDU.Index() got %s %t, expected .10.1.2", index, ok)
+	}
+	if oid, err := pdu.OID(); err != nil || len(oid) != 13 {
+		t.Errorf("SnmpPDU.OID() got %s %v", oid, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("MustParseOID() of a bad OID didn't panic")
+		}
+	}()
+	MustParseOID(".1.3.six")
+}
+
+var testsOIDMarshal = []struct {
+	oid OID
+	ber []byte
+}{
+	{OID{1, 3, 6, 1, 2, 1, 1, 1, 0}, []byte{0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x01, 0x00}},
+	{OID{1, 3, 6, 1, 4, 1, 2636}, []byte{0x06, 0x07, 0x2b, 0x06, 0x01, 0x04, 0x01, 0x94, 0x4c}},
+	{OID{1, 3, 4294967295}, []byte{0x06, 0x06, 0x2b, 0x8f, 0xff, 0xff, 0xff, 0x7f}},
+}
+
+func TestOIDMarshal(t *testing.T) {
+	for i, test := range testsOIDMarshal {
+		ber, err := test.oid.Marshal()
+		if err != nil || !reflect.DeepEqual(ber, test.ber) {
+			t.Errorf("#%d: Marshal() of %s got % x %v, expected % x", i, test.oid, ber, err, test.ber)
+		}
+		var oid OID
+		if err := oid.Unmarshal(test.ber); err != nil || !oid.Equal(test.oid) {
+			t.Errorf("#%d: Unmarshal() got %s %v, expected %s", i, oid, err, test.oid)
+		}
+	}
+	// long form lengths are accepted
+	var oid OID
+	if err := oid.Unmarshal([]byte{0x06, 0x81, 0x02, 0x2b, 0x06}); err != nil || !oid.Equal(OID{1, 3, 6}) {
+		t.Errorf("Unmarshal() of a long form length got %s %v", oid, err)
+	}
+	for _, ber := range [][]byte{
+		nil,
+		{0x06, 0x00},
+		{0x04, 0x01, 0x2b},
+		{0x06, 0x03, 0x2b, 0x06},
+		{0x06, 0x84, 0x01},
+		{0x06, 0x88, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		{0x06, 0x80, 0x2b},
+		{0x06, 0x02, 0x2b, 0x06, 0x01},
+		{0x06, 0x81, 0x02, 0x2b, 0x06, 0x00},
+	} {
+		if err := oid.Unmarshal(ber); err == nil {
+			t.Errorf("Unmarshal() of % x expected an error", ber)
+		}
+	}
+}
+
+// decodeVarbinds returns pdus as decoded from a response carrying them
+func decodeVarbinds(tb testing.TB, pdus []SnmpPDU) []SnmpPDU {
+	response := &SnmpPacket{Version: Version2c, Community: "public", PDUType: GetResponse}
+	msg, err := response.marshalMsg(pdus, GetResponse, 1)
+	if err != nil {
+		tb.Fatalf("marshalMsg() err returned: %v", err)
+	}
+	decoded, err := new(GoSNMP).unmarshal(msg)
+	if err != nil {
+		tb.Fatalf("unmarshal() err returned: %v", err)
+	}
+	return decoded.Variables
+}
+
+func TestSnmpPDUDecodedOID(t *testing.T) {
+	ifDescr := MustParseOID(".1.3.6.1.2.1.2.2.1.2")
+	pdu := decodeVarbinds(t, []SnmpPDU{{Name: ".1.3.6.1.2.1.2.2.1.2.7", Type: OctetString, Value: "eth0"}})[0]
+
+	// the OID decoded from the response is kept, rather than parsing Name
+	allocs := testing.AllocsPerRun(100, func() {
+		pdu.OID()
+		pdu.Index(ifDescr)
+	})
+	if allocs != 0 {
+		t.Errorf("OID() and Index() of a decoded varbind made %v allocations", allocs)
+	}
+	if index, ok := pdu.Index(ifDescr); !ok || !index.Equal(OID{7}) {
+		t.Errorf("Index() got %s %v, expected .7", index, ok)
+	}
+
+	// once Name is changed, it's parsed
+	pdu.Name = ".1.3.6.1.2.1.2.2.1.2.8"
+	if index, ok := pdu.Index(ifDescr); !ok || !index.Equal(OID{8}) {
+		t.Errorf("Index() of a changed Name got %s %v, expected .8", index, ok)
+	}
+	if oid, err := (SnmpPDU{Name: ".1.3.x"}).OID(); err == nil {
+		t.Errorf("OID() of a bad Name got %s, expected an error", oid)
+	}
+}
+
+func TestGetOIDs(t *testing.T) {
+	x := testClient(t, echoIndex)
+	defer x.Transport.Close()
+
+	oids := []OID{{1, 3, 6, 1, 2, 1, 2, 2, 1, 2, 3}, {1, 3, 6, 1, 2, 1, 2, 2, 1, 2, 5}}
+	for _, get := range []func([]OID) (*SnmpPacket, error){x.GetOIDs, x.GetNextOIDs} {
+		result, err := get(oids)
+		if err != nil {
+			t.Fatalf("err returned: %v", err)
+		}
+		if got := pduString(result.Variables); got != ".1.3.6.1.2.1.2.2.1.2.3=0x2:3 .1.3.6.1.2.1.2.2.1.2.5=0x2:5" {
+			t.Errorf("got |%s|", got)
+		}
+	}
+}
+
+func BenchmarkSnmpPDUIndex(b *testing.B) {
+	ifDescr := MustParseOID(".1.3.6.1.2.1.2.2.1.2")
+	pdu := decodeVarbinds(b, []SnmpPDU{{Name: ".1.3.6.1.2.1.2.2.1.2.7", Type: OctetString, Value: "eth0"}})[0]
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok := pdu.Index(ifDescr); !ok {
+			b.Fatal("Index() of a decoded varbind failed")
+		}
+	}
+}
@@ -3,6 +3,7 @@ package gosnmp
 import (
 	"fmt"
 	"net"
+	"time"
 )
 
 /*
@@ -26,7 +27,7 @@ func GenPacket(community string, version SnmpVersion, reqType PDUType, oids []st
 	var pdus []SnmpPDU
 	var err error
 	for _, oid := range oids {
-		pdus = append(pdus, SnmpPDU{oid, Null, nil})
+		pdus = append(pdus, SnmpPDU{Name: oid, Type: Null, Value: nil})
 	}
 
 	// build up SnmpPacket
@@ -45,40 +46,43 @@ func GenPacket(community string, version SnmpVersion, reqType PDUType, oids []st
 	return packet, nil
 }
 
-//SendPacket sends a packet generated with GenPacket, or other functions
+//SendPacket sends a packet generated with GenPacket, or other functions. It
+//waits for the response until the read deadline set on conn, if any.
 func SendPacket(packet []byte, conn net.Conn) (result *SnmpPacket, err error) {
+	if conn == nil {
+		return nil, fmt.Errorf("&GoSNMP.Conn is missing. Provide a connection or use Connect()")
+	}
+	return SendPacketTransport(packet, callerConnTransport(conn), time.Time{})
+}
+
+//SendPacketTransport sends a packet on transport, and waits until deadline
+//(or indefinitely, if it's zero) for the response
+func SendPacketTransport(packet []byte, transport Transport, deadline time.Time) (result *SnmpPacket, err error) {
 	defer func() {
 		if e := recover(); e != nil {
 			err = fmt.Errorf("recover: %v", e)
 		}
 	}()
-	if conn == nil {
-		return nil, fmt.Errorf("&GoSNMP.Conn is missing. Provide a connection or use Connect()")
-	}
-	_, err = conn.Write(packet)
+	err = transport.Send(packet)
 	if err != nil {
 		err = fmt.Errorf("Error writing to socket: %s", err.Error())
 		return nil, err
 	}
 
-	// FIXME: If our packet exceeds our buf size we'll get a partial read
-	// and this request, and the next will fail. The correct logic would be
-	// to realloc and read more if pack len > buff size.
-	resp := make([]byte, rxBufSize, rxBufSize)
-	var n int
-	n, err = conn.Read(resp)
+	var resp []byte
+	resp, err = transport.Receive(deadline)
 	if err != nil {
-		err = fmt.Errorf("Error reading from UDP: %s", err.Error())
+		err = fmt.Errorf("Error reading from socket: %w", err)
 		return nil, err
 	}
 
-	result, err = unmarshal(resp[:n])
+	result, err = Default.unmarshal(resp)
 	if err != nil {
-		err = fmt.Errorf("Unable to decode packet: %s", err.Error())
+		err = fmt.Errorf("%w: %s", ErrDecode, err.Error())
 		return nil, err
 	}
 	if result == nil || len(result.Variables) < 1 {
-		err = fmt.Errorf("Unable to decode packet: nil")
+		err = fmt.Errorf("%w: nil", ErrDecode)
 		return nil, err
 	}
 	return result, nil
@@ -0,0 +1,221 @@
+// Copyright 2012-2014 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+const defaultWalkers = 4 // defaultWalkers is the default number of subtrees a ParallelWalk walks at once
+
+// ParallelWalkOptions configures a ParallelWalk
+type ParallelWalkOptions struct {
+	Subtrees []string // Subtrees are the parts of the root to walk (default: its children, found with GETNEXT)
+	Workers  int      // Workers is the number of subtrees walked at once (default: 4)
+	Ordered  bool     // Ordered delivers values in OID order, rather than as they arrive
+}
+
+// walkPart is one subtree of a ParallelWalk, and the values walked but not
+// yet delivered in order
+type walkPart struct {
+	root  OID
+	first *SnmpPDU // first is the value of root itself, if root is an instance
+
+	values []SnmpPDU
+	done   bool
+}
+
+// ParallelWalk retrieves the subtree of values below rootOid by walking its
+// parts concurrently: the Subtrees of opts, or else the children of rootOid eg
+// .1.3.6.1.2.1.1 (system) and .1.3.6.1.2.1.2 (interfaces) below mib-2. Each
+// part is a BulkWalk (a Walk for SNMPv1), sharing this GoSNMP.
+//
+// walkFn is called for one value at a time. With opts.Ordered the values are
+// in OID order, buffering the parts walked ahead of the one being delivered;
+// otherwise they're delivered as they arrive, in order within each part. The
+// first error stops the walk.
+func (x *GoSNMP) ParallelWalk(rootOid string, opts ParallelWalkOptions, walkFn WalkFunc) error {
+	return x.ParallelWalkContext(context.Background(), rootOid, opts, walkFn)
+}
+
+// ParallelWalkContext is ParallelWalk, stopping with ctx.Err() once ctx is
+// done
+func (x *GoSNMP) ParallelWalkContext(ctx context.Context, rootOid string, opts ParallelWalkOptions, walkFn WalkFunc) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if rootOid == "" || rootOid == "." {
+		rootOid = baseOid
+	}
+	root, err := ParseOID(rootOid)
+	if err != nil {
+		return err
+	}
+	var parts []*walkPart
+	if len(opts.Subtrees) > 0 {
+		for _, subtree := range opts.Subtrees {
+			oid, err := ParseOID(subtree)
+			if err != nil {
+				return err
+			}
+			parts = append(parts, &walkPart{root: oid})
+		}
+		sort.Slice(parts, func(i, j int) bool { return parts[i].root.Less(parts[j].root) })
+	} else if parts, err = x.walkParts(ctx, root); err != nil {
+		return err
+	}
+	x.logPrintf("ParallelWalk of %s in %d parts", root, len(parts))
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = defaultWalkers
+	}
+
+	var mu sync.Mutex // guards the parts, and serialises unordered walkFn calls
+	cond := sync.NewCond(&mu)
+	var once sync.Once
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() { firstErr = err })
+		cancel()
+	}
+	// deliver passes a value to walkFn, or buffers it until its part's turn
+	deliver := func(part *walkPart, pdu SnmpPDU) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if ctx.Err() != nil {
+			// stopped by an error in another part
+			return ctx.Err()
+		}
+		if opts.Ordered {
+			part.values = append(part.values, pdu)
+			cond.Signal()
+			return nil
+		}
+		return walkFn(pdu)
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, workers)
+	go func() {
+		for _, part := range parts {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				// release the parts never started
+				mu.Lock()
+				part.done = true
+				cond.Broadcast()
+				mu.Unlock()
+				continue
+			}
+			wg.Add(1)
+			go func(part *walkPart) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				var err error
+				if part.first != nil {
+					err = deliver(part, *part.first)
+				}
+				if err == nil {
+					err = x.walk(ctx, GetBulkRequest, part.root.String(), func(pdu SnmpPDU) error {
+						return deliver(part, pdu)
+					})
+				}
+				if err != nil {
+					fail(err)
+				}
+				mu.Lock()
+				part.done = true
+				cond.Broadcast()
+				mu.Unlock()
+			}(part)
+		}
+	}()
+
+	if opts.Ordered {
+		// deliver each part in turn, as it's walked
+		for _, part := range parts {
+			for {
+				mu.Lock()
+				for len(part.values) == 0 && !part.done {
+					cond.Wait()
+				}
+				values, done := part.values, part.done
+				part.values = nil
+				mu.Unlock()
+
+				for _, pdu := range values {
+					if ctx.Err() == nil {
+						if err := walkFn(pdu); err != nil {
+							fail(err)
+						}
+					}
+				}
+				if done {
+					break
+				}
+			}
+		}
+	}
+	// wait for every part to be walked or abandoned
+	mu.Lock()
+	for _, part := range parts {
+		for !part.done {
+			cond.Wait()
+		}
+	}
+	mu.Unlock()
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// walkParts finds the children of root with GETNEXT, probing past the
+// subtree of each child for the next
+func (x *GoSNMP) walkParts(ctx context.Context, root OID) ([]*walkPart, error) {
+	var parts []*walkPart
+	probe := root
+	for {
+		response, err := x.getBatched(ctx, GetNextRequest, []string{probe.String()})
+		if err != nil {
+			return nil, err
+		}
+		if response.Error != NoError || len(response.Variables) == 0 {
+			// SNMPv1 agents report the end of the MIB as noSuchName
+			break
+		}
+		v := response.Variables[0]
+		if v.Type == EndOfMibView || v.Type == NoSuchObject || v.Type == NoSuchInstance {
+			break
+		}
+		oid, err := ParseOID(v.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !oid.IsDescendantOf(root) {
+			break
+		}
+		if oid.Compare(probe) <= 0 {
+			return nil, fmt.Errorf("OID not increasing: %s", v.Name)
+		}
+		part := &walkPart{root: oid[: len(root)+1 : len(root)+1]}
+		if len(oid) == len(part.root) {
+			part.first = &v
+		}
+		parts = append(parts, part)
+		if part.root[len(root)] == math.MaxUint32 {
+			break
+		}
+		probe = part.root.Append(math.MaxUint32)
+	}
+	return parts, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2012-2014 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// testParallelMIB has 5 subtrees below .1.3.6.1.2.1, the last an instance
+func testParallelMIB() []string {
+	var mib []string
+	for _, subtree := range []int{1, 2, 4, 11, 31} {
+		for i := 1; i <= 3*subtree; i++ {
+			mib = append(mib, fmt.Sprintf(".1.3.6.1.2.1.%d.1.%d", subtree, i))
+		}
+	}
+	return append(mib, ".1.3.6.1.2.1.40", ".1.3.6.1.4.1.9.1.0")
+}
+
+var testsParallelWalk = []struct {
+	opts   ParallelWalkOptions
+	values int
+}{
+	{ParallelWalkOptions{Ordered: true}, 148},
+	{ParallelWalkOptions{Ordered: true, Workers: 1}, 148},
+	{ParallelWalkOptions{Workers: 3}, 148},
+	{ParallelWalkOptions{Subtrees: []string{".1.3.6.1.2.1.11", ".1.3.6.1.2.1.2"}, Ordered: true}, 39},
+}
+
+func TestParallelWalk(t *testing.T) {
+	mib := testParallelMIB()
+	for i, test := range testsParallelWalk {
+		for _, version := range []SnmpVersion{Version1, Version2c} {
+			x := testClient(t, nextAgent(mib))
+			x.Version = version
+			x.MaxRepetitions = 4
+
+			var names []string
+			err := x.ParallelWalk(".1.3.6.1.2.1", test.opts, func(dataUnit SnmpPDU) error {
+				names = append(names, dataUnit.Name)
+				return nil
+			})
+			x.Transport.Close()
+			if err != nil {
+				t.Errorf("#%d: ParallelWalk() err returned: %v", i, err)
+				continue
+			}
+			if len(names) != test.values {
+				t.Errorf("#%d: ParallelWalk() got %d values, expected %d", i, len(names), test.values)
+				continue
+			}
+			ordered := sort.SliceIsSorted(names, func(a, b int) bool {
+				return MustParseOID(names[a]).Less(MustParseOID(names[b]))
+			})
+			if test.opts.Ordered && !ordered {
+				t.Errorf("#%d: ParallelWalk() got values out of order: %v", i, names)
+			}
+			if len(test.opts.Subtrees) == 0 {
+				sort.Slice(names, func(a, b int) bool {
+					return MustParseOID(names[a]).Less(MustParseOID(names[b]))
+				})
+				if !reflect.DeepEqual(names, mib[:len(mib)-1]) {
+					t.Errorf("#%d: ParallelWalk() got %v", i, names)
+				}
+			}
+		}
+	}
+}
+
+func TestParallelWalkError(t *testing.T) {
+	x := testClient(t, nextAgent(testParallelMIB()))
+	defer x.Transport.Close()
+
+	stop := errors.New("stop")
+	for _, ordered := range []bool{false, true} {
+		values := 0
+		err := x.ParallelWalk(".1.3.6.1.2.1", ParallelWalkOptions{Ordered: ordered}, func(dataUnit SnmpPDU) error {
+			values++
+			if values == 10 {
+				return stop
+			}
+			return nil
+		})
+		if err != stop || values != 10 {
+			t.Errorf("ParallelWalk() got err %v after %d values, expected stop after 10", err, values)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2012-2014 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"context"
+	"sort"
+)
+
+// Table is a conceptual table read by GetTable eg ifTable
+type Table struct {
+	Entry   OID        // Entry is the OID of the table's entry eg ifEntry .1.3.6.1.2.1.2.2.1
+	Columns []uint32   // Columns are the column numbers requested, or nil for every column
+	Rows    []TableRow // Rows are in index order
+}
+
+// TableRow is one row of a Table. A sparse table may have holes: columns with
+// no value for some rows, which have no cell.
+type TableRow struct {
+	Index OID                // Index is the OID of the row below each column eg .3 for ifIndex 3
+	Cells map[uint32]SnmpPDU // Cells are keyed by column number
+}
+
+// GetTable reads the columns of the table below entryOid eg ifEntry
+// ".1.3.6.1.2.1.2.2.1", regrouping the values into rows by index. The columns
+// are walked together, several to a GETBULK request; with no columns the
+// whole table is walked.
+func (x *GoSNMP) GetTable(entryOid string, columns ...uint32) (*Table, error) {
+	return x.GetTableContext(context.Background(), entryOid, columns...)
+}
+
+// GetTableContext is GetTable, stopping with ctx.Err() once ctx is done
+func (x *GoSNMP) GetTableContext(ctx context.Context, entryOid string, columns ...uint32) (*Table, error) {
+	entry, err := ParseOID(entryOid)
+	if err != nil {
+		return nil, err
+	}
+	roots := []OID{entry}
+	if len(columns) > 0 {
+		roots = make([]OID, len(columns))
+		for i, column := range columns {
+			roots[i] = entry.Append(column)
+		}
+	}
+
+	table := &Table{Entry: entry, Columns: columns}
+	rows := make(map[string]int) // the position in Rows of each index
+	err = x.walkColumns(ctx, roots, func(_ int, oid OID, pdu SnmpPDU) error {
+		if len(oid) < len(entry)+2 {
+			// not a cell: the entry has no index
+			return nil
+		}
+		index := oid[len(entry)+1:]
+		key := index.String()
+		i, ok := rows[key]
+		if !ok {
+			i = len(table.Rows)
+			rows[key] = i
+			table.Rows = append(table.Rows, TableRow{Index: index, Cells: make(map[uint32]SnmpPDU)})
+		}
+		table.Rows[i].Cells[oid[len(entry)]] = pdu
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(table.Rows, func(i, j int) bool {
+		return table.Rows[i].Index.Less(table.Rows[j].Index)
+	})
+	return table, nil
+}
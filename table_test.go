@@ -0,0 +1,80 @@
+// Copyright 2012-2014 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// ifTable of 3 interfaces, without an ifDescr for interface 2
+var testIfTable = []string{
+	".1.3.6.1.2.1.2.1.0",
+	".1.3.6.1.2.1.2.2.1.1.1",
+	".1.3.6.1.2.1.2.2.1.1.2",
+	".1.3.6.1.2.1.2.2.1.1.3",
+	".1.3.6.1.2.1.2.2.1.2.1",
+	".1.3.6.1.2.1.2.2.1.2.3",
+	".1.3.6.1.2.1.2.2.1.10.1",
+	".1.3.6.1.2.1.2.2.1.10.2",
+	".1.3.6.1.2.1.2.2.1.10.3",
+	".1.3.6.1.2.1.2.2.1.11.1",
+	".1.3.6.1.2.1.4.1.0",
+}
+
+var testsGetTable = []struct {
+	version  SnmpVersion
+	columns  []uint32
+	cells    string // the columns of each row
+	requests int
+}{
+	{Version2c, []uint32{1, 2, 10}, "1:[1 2 10] 2:[1 10] 3:[1 2 10]", 2},
+	{Version1, []uint32{1, 2, 10}, "1:[1 2 10] 2:[1 10] 3:[1 2 10]", 4},
+	{Version2c, []uint32{2}, "1:[2] 3:[2]", 1},
+	{Version2c, nil, "1:[1 2 10 11] 2:[1 10] 3:[1 2 10]", 4},
+}
+
+func TestGetTable(t *testing.T) {
+	for i, test := range testsGetTable {
+		requests := 0
+		agent := nextAgent(testIfTable)
+		x := testClient(t, func(request *SnmpPacket) (*SnmpPacket, []SnmpPDU) {
+			requests++
+			return agent(request)
+		})
+		x.Version = test.version
+		x.MaxRepetitions = 3
+
+		table, err := x.GetTable(".1.3.6.1.2.1.2.2.1", test.columns...)
+		x.Transport.Close()
+		if err != nil {
+			t.Errorf("#%d: GetTable() err returned: %v", i, err)
+			continue
+		}
+		var cells []string
+		for _, row := range table.Rows {
+			var columns []int
+			for column, pdu := range row.Cells {
+				if pdu.Type != Integer || pdu.Value != int(row.Index[0]) {
+					t.Errorf("#%d: row %s column %d got %v", i, row.Index, column, pdu)
+				}
+				columns = append(columns, int(column))
+			}
+			sort.Ints(columns)
+			cells = append(cells, fmt.Sprintf("%d:%v", row.Index[0], columns))
+		}
+		if got := fmt.Sprint(cells); got != "["+test.cells+"]" {
+			t.Errorf("#%d: GetTable() got rows %s, expected [%s]", i, got, test.cells)
+		}
+		if !reflect.DeepEqual(table.Columns, test.columns) || !table.Entry.Equal(MustParseOID(".1.3.6.1.2.1.2.2.1")) {
+			t.Errorf("#%d: GetTable() got entry %s and columns %v", i, table.Entry, table.Columns)
+		}
+		if requests != test.requests {
+			t.Errorf("#%d: GetTable() sent %d requests, expected %d", i, requests, test.requests)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright 2012-2014 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Transport sends and receives whole SNMP messages. GoSNMP uses a Transport
+// in place of Conn when one is set; otherwise Conn is wrapped by
+// NewUDPTransport or NewTCPTransport.
+//
+// Send may be called from many goroutines at once, Receive from one
+// goroutine at a time.
+type Transport interface {
+	// Send sends one message
+	Send(msg []byte) error
+
+	// Receive returns the next message. It fails with an error satisfying
+	// errors.Is(err, os.ErrDeadlineExceeded) if none arrives before
+	// deadline, or blocks if deadline is zero. A message too large to
+	// receive whole is returned truncated, with an error wrapping
+	// ErrTruncated.
+	Receive(deadline time.Time) ([]byte, error)
+
+	// Close closes the Transport, failing any blocked Receive
+	Close() error
+}
+
+// ErrTruncated is wrapped by the error for a received message that's larger
+// than the receive buffer
+var ErrTruncated = errors.New("Received message is truncated")
+
+// udpTransport sends each message as a datagram on a connected socket. buf
+// is one byte larger than the largest message received, to detect
+// truncation.
+type udpTransport struct {
+	conn         net.Conn
+	buf          []byte
+	keepDeadline bool // keepDeadline leaves the read deadline of conn alone when Receive's is zero
+}
+
+// NewUDPTransport returns a Transport sending datagrams on conn, typically
+// a connected *net.UDPConn
+func NewUDPTransport(conn net.Conn) Transport {
+	return &udpTransport{conn: conn, buf: make([]byte, rxBufSize+1)}
+}
+
+func (t *udpTransport) Send(msg []byte) error {
+	_, err := t.conn.Write(msg)
+	return err
+}
+
+func (t *udpTransport) Receive(deadline time.Time) ([]byte, error) {
+	if !deadline.IsZero() || !t.keepDeadline {
+		if err := t.conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+	n, err := t.conn.Read(t.buf)
+	if err != nil {
+		return nil, err
+	}
+	return received(t.buf, n)
+}
+
+// received copies the message read into buf, reporting truncation if it
+// filled buf
+func received(buf []byte, n int) ([]byte, error) {
+	max := len(buf) - 1
+	if n > max {
+		msg := make([]byte, max)
+		copy(msg, buf)
+		return msg, fmt.Errorf("%w: larger than %d bytes", ErrTruncated, max)
+	}
+	msg := make([]byte, n)
+	copy(msg, buf[:n])
+	return msg, nil
+}
+
+func (t *udpTransport) Close() error {
+	return t.conn.Close()
+}
+
+// tcpTransport frames messages on a stream by their BER length
+type tcpTransport struct {
+	conn         net.Conn
+	r            *bufio.Reader
+	keepDeadline bool // keepDeadline leaves the read deadline of conn alone when Receive's is zero
+}
+
+// NewTCPTransport returns a Transport framing messages on conn, typically a
+// *net.TCPConn - RFC 3430
+func NewTCPTransport(conn net.Conn) Transport {
+	return &tcpTransport{conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (t *tcpTransport) Send(msg []byte) error {
+	_, err := t.conn.Write(msg)
+	return err
+}
+
+// Receive reads one message. A stream can't be resynchronised after a
+// timeout part way through a message, so it should then be closed.
+func (t *tcpTransport) Receive(deadline time.Time) ([]byte, error) {
+	if !deadline.IsZero() || !t.keepDeadline {
+		if err := t.conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+	return readMessage(t.r)
+}
+
+func (t *tcpTransport) Close() error {
+	return t.conn.Close()
+}
+
+// newConnTransport wraps conn according to whether it's a stream
+func newConnTransport(conn net.Conn) Transport {
+	if isStream(conn) {
+		return NewTCPTransport(conn)
+	}
+	return NewUDPTransport(conn)
+}
+
+// callerConnTransport wraps conn as newConnTransport does, but a zero
+// deadline leaves any read deadline the caller set on conn in place
+func callerConnTransport(conn net.Conn) Transport {
+	if isStream(conn) {
+		return &tcpTransport{conn: conn, r: bufio.NewReader(conn), keepDeadline: true}
+	}
+	return &udpTransport{conn: conn, buf: make([]byte, rxBufSize+1), keepDeadline: true}
+}
+
+// isStream reports whether conn is a stream (eg TCP) rather than a datagram
+// connection, so messages must be framed by their BER length
+func isStream(conn net.Conn) bool {
+	_, datagram := conn.(net.PacketConn)
+	return !datagram
+}
+
+// maxStreamMsgSize limits the messages read from a stream
+const maxStreamMsgSize = 1 << 24
+
+// readMessage reads exactly one message from a stream, using the length of
+// its outer SEQUENCE - RFC 3430 2.1
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	header := make([]byte, 2, 6)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if PDUType(header[0]) != Sequence {
+		return nil, fmt.Errorf("Invalid message header %#x", header[0])
+	}
+	length := int(header[1])
+	if length > 127 {
+		numOctets := length & 127
+		if numOctets == 0 || numOctets > 4 {
+			return nil, fmt.Errorf("Invalid message length octets %#x", header[1])
+		}
+		header = header[:2+numOctets]
+		if _, err := io.ReadFull(r, header[2:]); err != nil {
+			return nil, err
+		}
+		length = 0
+		for _, b := range header[2:] {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxStreamMsgSize {
+		return nil, fmt.Errorf("Message length %d exceeds %d", length, maxStreamMsgSize)
+	}
+	msg := make([]byte, len(header)+length)
+	copy(msg, header)
+	if _, err := io.ReadFull(r, msg[len(header):]); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// errPipeClosed is returned by a closed pipeTransport
+var errPipeClosed = fmt.Errorf("Pipe transport is closed")
+
+// pipeTransport is one end of an in-memory pipe
+type pipeTransport struct {
+	in     <-chan []byte
+	out    chan<- []byte
+	closed chan struct{}
+	once   *sync.Once
+}
+
+// NewPipeTransport returns the two ends of an in-memory pipe: a message
+// sent on one is received by the other. Closing either end closes both.
+// It lets a GoSNMP (with Transport set to one end) be driven against a fake
+// agent without sockets, eg in tests.
+func NewPipeTransport() (Transport, Transport) {
+	a, b := make(chan []byte, 16), make(chan []byte, 16)
+	closed, once := make(chan struct{}), new(sync.Once)
+	return &pipeTransport{a, b, closed, once}, &pipeTransport{b, a, closed, once}
+}
+
+func (p *pipeTransport) Send(msg []byte) error {
+	select {
+	case <-p.closed:
+		return errPipeClosed
+	default:
+	}
+	msg = append([]byte(nil), msg...)
+	select {
+	case p.out <- msg:
+		return nil
+	case <-p.closed:
+		return errPipeClosed
+	}
+}
+
+func (p *pipeTransport) Receive(deadline time.Time) ([]byte, error) {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case msg := <-p.in:
+		return msg, nil
+	case <-p.closed:
+		return nil, errPipeClosed
+	case <-timeout:
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (p *pipeTransport) Close() error {
+	p.once.Do(func() { close(p.closed) })
+	return nil
+}
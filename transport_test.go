@@ -0,0 +1,155 @@
+// Copyright 2012-2014 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testsReadMessage = []struct {
+	in  []byte
+	out []byte // nil for an error
+}{
+	{[]byte{0x30, 0x03, 0x02, 0x01, 0x01}, []byte{0x30, 0x03, 0x02, 0x01, 0x01}},
+	{[]byte{0x30, 0x81, 0x03, 0x02, 0x01, 0x01, 0x30}, []byte{0x30, 0x81, 0x03, 0x02, 0x01, 0x01}},
+	{[]byte{0x30, 0x82, 0x00, 0x03, 0x02, 0x01, 0x01}, []byte{0x30, 0x82, 0x00, 0x03, 0x02, 0x01, 0x01}},
+	{[]byte{0x04, 0x01, 0x00}, nil},             // not a SEQUENCE
+	{[]byte{0x30, 0x85, 0, 0, 0, 0, 1, 0}, nil}, // too many length octets
+	{[]byte{0x30, 0x84, 0x7f, 0, 0, 0}, nil},    // too long
+	{[]byte{0x30, 0x05, 0x02, 0x01}, nil},       // truncated
+}
+
+func TestReadMessage(t *testing.T) {
+	for i, test := range testsReadMessage {
+		msg, err := readMessage(bufio.NewReader(bytes.NewReader(test.in)))
+		if test.out == nil {
+			if err == nil {
+				t.Errorf("#%d: readMessage() expected an error, got %x", i, msg)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(msg, test.out) {
+			t.Errorf("#%d: readMessage() got %x, %v expected %x", i, msg, err, test.out)
+		}
+	}
+}
+
+func TestPipeTransport(t *testing.T) {
+	client, agent := NewPipeTransport()
+
+	msg := []byte{0x30, 0x00}
+	if err := client.Send(msg); err != nil {
+		t.Fatalf("Send() err returned: %v", err)
+	}
+	msg[1] = 0xff // the pipe holds a copy
+	if got, err := agent.Receive(time.Time{}); err != nil || !bytes.Equal(got, []byte{0x30, 0x00}) {
+		t.Errorf("Receive() got %x, %v", got, err)
+	}
+
+	start := time.Now()
+	if _, err := agent.Receive(start.Add(50 * time.Millisecond)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Receive() got err %v, expected %v", err, os.ErrDeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Receive() took %s to time out", elapsed)
+	}
+
+	// closing one end closes both
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		client.Close()
+	}()
+	if _, err := agent.Receive(time.Time{}); err == nil {
+		t.Errorf("Receive() on a closed pipe expected an error")
+	}
+	if err := agent.Send(msg); err == nil {
+		t.Errorf("Send() on a closed pipe expected an error")
+	}
+}
+
+func TestSendPacketTransport(t *testing.T) {
+	client, agent := NewPipeTransport()
+	defer client.Close()
+	go testAgent(t, agent, echoIndex)
+
+	packet, err := GenPacket("public", Version2c, GetRequest, []string{".1.3.6.1.2.1.2.2.1.2.4"})
+	if err != nil {
+		t.Fatalf("GenPacket() err returned: %v", err)
+	}
+	result, err := SendPacketTransport(packet, client, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("SendPacketTransport() err returned: %v", err)
+	}
+	if result.Variables[0].Value != 4 {
+		t.Errorf("SendPacketTransport() got %v", result.Variables[0])
+	}
+}
+
+func TestSendPacketConnDeadline(t *testing.T) {
+	silent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer silent.Close()
+	conn, err := net.DialUDP("udp", nil, silent.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("unable to dial: %v", err)
+	}
+	defer conn.Close()
+
+	packet, err := GenPacket("public", Version2c, GetRequest, []string{".1.3.6.1.2.1.1.1.0"})
+	if err != nil {
+		t.Fatalf("GenPacket() err returned: %v", err)
+	}
+	// the deadline set by the caller ends the wait for a lost reply
+	conn.SetDeadline(time.Now().Add(200 * time.Millisecond))
+	start := time.Now()
+	if _, err = SendPacket(packet, conn); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("SendPacket() got err %v, expected %v", err, os.ErrDeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("SendPacket() took %s to time out", elapsed)
+	}
+}
+
+func TestUDPTransportTruncated(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer conn.Close()
+	go namedAgent(t, conn, strings.Repeat("x", 200))
+
+	// a receive buffer for messages of up to 128 bytes
+	client, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("unable to dial: %v", err)
+	}
+	transport := &udpTransport{conn: client, buf: make([]byte, 129)}
+	defer transport.Close()
+
+	x := &GoSNMP{
+		Transport: transport,
+		Community: "public",
+		Version:   Version2c,
+		Timeout:   time.Duration(5) * time.Second,
+		Retries:   3,
+	}
+	start := time.Now()
+	_, err = x.Get([]string{".1.3.6.1.2.1.1.5.0"})
+	if !errors.Is(err, ErrTruncated) {
+		t.Errorf("Get() got err %v, expected %v", err, ErrTruncated)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Get() took %s, expected the truncated response not to be retried", elapsed)
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright 2012-2014 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// Receiving notifications: SNMPv1 Trap-PDUs, SNMPv2 traps and informs.
+//
+
+// TrapListener receives SNMP notifications on a UDP port, and passes them to
+// OnNewTrap. InformRequests are acknowledged with a Response PDU
+// automatically, after OnNewTrap returns.
+//
+// Params supplies the Logger and, for SNMPv3, the MsgFlags and
+// SecurityParameters used to authenticate and decrypt notifications; keys are
+// localized against the engine ID of each notification. Params defaults to
+// Default. Authenticated SNMPv3 notifications that are outside the time
+// window of their engine (as learned from earlier notifications) are
+// discarded as replays.
+type TrapListener struct {
+	OnNewTrap func(s *SnmpPacket, u *net.UDPAddr)
+	Params    *GoSNMP
+
+	udpListener
+	enginesMu sync.Mutex
+	engines   map[string]*engineRecord // engines notifications were received from, by engine ID
+}
+
+// engineRecord is what a TrapListener has learned of the boots and time of
+// an authoritative engine - RFC 3414 2.3
+type engineRecord struct {
+	boots      uint32    // boots is snmpEngineBoots
+	engineTime uint32    // engineTime is latestReceivedEngineTime
+	syncedAt   time.Time // syncedAt is when engineTime was received
+}
+
+// NewTrapListener returns a TrapListener, ready for Listen
+func NewTrapListener() *TrapListener {
+	return &TrapListener{}
+}
+
+// Listen binds addr, eg "0.0.0.0:162", and handles notifications until Close
+// is called or the socket fails. It returns nil after Close.
+func (t *TrapListener) Listen(addr string) error {
+	if t.OnNewTrap == nil {
+		return fmt.Errorf("TrapListener.OnNewTrap is required")
+	}
+	if t.Params == nil {
+		t.Params = Default
+	}
+	return t.serve(addr, t.Params, t.handle)
+}
+
+// handle decodes one notification, and acknowledges informs
+func (t *TrapListener) handle(msg []byte, remote *net.UDPAddr) {
+	t.Params.secMu.Lock()
+	packet, err := t.Params.unmarshalResponse(msg)
+	t.Params.secMu.Unlock()
+	if err != nil {
+		t.Params.logPrintf("TrapListener: discarding packet from %s: %v", remote, err)
+		return
+	}
+	switch packet.PDUType {
+	case Trap, SNMPv2Trap, InformRequest:
+	default:
+		t.Params.logPrintf("TrapListener: discarding %#x PDU from %s", packet.PDUType, remote)
+		return
+	}
+	if !t.inTimeWindow(packet) {
+		t.Params.logPrintf("TrapListener: discarding %#x PDU from %s: not in time window", packet.PDUType, remote)
+		return
+	}
+
+	t.OnNewTrap(packet, remote)
+
+	if packet.PDUType == InformRequest {
+		if err := t.acknowledge(packet, remote); err != nil {
+			t.Params.logPrintf("TrapListener: unable to acknowledge inform from %s: %v", remote, err)
+		}
+	}
+}
+
+// inTimeWindow checks an authenticated SNMPv3 notification against the record
+// of its authoritative engine, which it updates - RFC 3414 3.2 step 7b. The
+// first notification of an engine starts its record.
+func (t *TrapListener) inTimeWindow(packet *SnmpPacket) bool {
+	if packet.Version != Version3 || packet.MsgFlags&AuthNoPriv == 0 {
+		return true
+	}
+	received := packet.SecurityParameters
+	boots, engineTime := received.AuthoritativeEngineBoots, received.AuthoritativeEngineTime
+	t.enginesMu.Lock()
+	defer t.enginesMu.Unlock()
+	r, ok := t.engines[received.AuthoritativeEngineID]
+	if !ok {
+		if t.engines == nil {
+			t.engines = make(map[string]*engineRecord)
+		}
+		t.engines[received.AuthoritativeEngineID] = &engineRecord{boots, engineTime, time.Now()}
+		return true
+	}
+	estimated := int64(r.engineTime) + int64(time.Since(r.syncedAt)/time.Second)
+	if r.boots == math.MaxInt32 || boots < r.boots ||
+		(boots == r.boots && int64(engineTime) < estimated-timeWindow) {
+		return false
+	}
+	if boots > r.boots || engineTime > r.engineTime {
+		r.boots, r.engineTime, r.syncedAt = boots, engineTime, time.Now()
+	}
+	return true
+}
+
+// acknowledge sends the Response PDU for an inform - RFC 3416 4.2.7. The
+// response echoes the varbinds, and for SNMPv3 the inform's security
+// parameters (engine ID, boots and time).
+func (t *TrapListener) acknowledge(inform *SnmpPacket, remote *net.UDPAddr) error {
+	response := &SnmpPacket{
+		Version:         inform.Version,
+		Community:       inform.Community,
+		MsgFlags:        inform.MsgFlags &^ Reportable,
+		SecurityModel:   inform.SecurityModel,
+		ContextEngineID: inform.ContextEngineID,
+		ContextName:     inform.ContextName,
+		PDUType:         GetResponse,
+		MsgID:           inform.MsgID,
+	}
+	if inform.Version == Version3 {
+		if t.Params.SecurityParameters == nil {
+			return fmt.Errorf("SNMPv3 requires SecurityParameters")
+		}
+		received := inform.SecurityParameters
+		t.Params.secMu.Lock()
+		sp := *t.Params.session()
+		t.Params.secMu.Unlock()
+		sp.AuthoritativeEngineID = received.AuthoritativeEngineID
+		sp.AuthoritativeEngineBoots = received.AuthoritativeEngineBoots
+		sp.AuthoritativeEngineTime = received.AuthoritativeEngineTime
+		response.SecurityParameters = &sp
+	}
+
+	msg, err := response.marshalMsg(inform.Variables, GetResponse, inform.RequestID)
+	if err != nil {
+		return err
+	}
+	return t.reply(msg, remote)
+}
+
+//
+// Sending notifications
+//
+
+// snmpTraps is the prefix of the generic trap OIDs, and sysUpTime.0 and
+// snmpTrapOID.0 the mandatory first varbinds of an SNMPv2 notification
+const (
+	snmpTraps   = ".1.3.6.1.6.3.1.1.5"
+	sysUpTime   = ".1.3.6.1.2.1.1.3.0"
+	snmpTrapOID = ".1.3.6.1.6.3.1.1.4.1.0"
+)
+
+// SnmpTrap is used to define an SNMP notification for SendTrap and
+// SendInform. For SNMPv2c/v3 the TrapOID is required, or derived from the
+// SNMPv1 fields as described in RFC 3584 3.1.
+type SnmpTrap struct {
+	Variables []SnmpPDU // Variables follow sysUpTime.0 and snmpTrapOID.0 (v2c/v3)
+	TrapOID   string    // TrapOID is the snmpTrapOID.0 value eg ".1.3.6.1.6.3.1.1.5.3" (v2c/v3)
+	Timestamp uint      // Timestamp is the sender's sysUpTime, in TimeTicks
+
+	// SNMPv1 Trap-PDU fields
+	Enterprise   string // Enterprise is the sysObjectID of the sender
+	AgentAddress string // AgentAddress defaults to the local address of Conn
+	GenericTrap  int    // GenericTrap is eg 0 coldStart .. 6 enterpriseSpecific
+	SpecificTrap int    // SpecificTrap is the enterprise specific trap code
+}
+
+// SendTrap sends an SNMP notification: a Trap-PDU for SNMPv1, otherwise an
+// SNMPv2-Trap PDU. Traps are unconfirmed, so SendTrap returns once the trap
+// is sent. For SNMPv3 this GoSNMP is the authoritative engine, so
+// SecurityParameters.AuthoritativeEngineID (with boots and time) must be set
+// to the local engine ID before Connect.
+func (x *GoSNMP) SendTrap(trap SnmpTrap) error {
+	if x.Version == Version1 {
+		if trap.Enterprise == "" {
+			return fmt.Errorf("SNMPv1 traps require an Enterprise")
+		}
+		if err := x.checkOidCount(len(trap.Variables)); err != nil {
+			return err
+		}
+		packetOut := x.mkSnmpPacket(Trap, 0, 0)
+		packetOut.Enterprise = trap.Enterprise
+		packetOut.AgentAddress = trap.AgentAddress
+		if packetOut.AgentAddress == "" {
+			packetOut.AgentAddress = x.localIPv4()
+		}
+		packetOut.GenericTrap = trap.GenericTrap
+		packetOut.SpecificTrap = trap.SpecificTrap
+		packetOut.Timestamp = trap.Timestamp
+		_, err := x.send(trap.Variables, packetOut)
+		return err
+	}
+
+	if x.Version == Version3 && x.SecurityParameters != nil &&
+		x.SecurityParameters.AuthoritativeEngineID == "" {
+		return fmt.Errorf("SNMPv3 traps require SecurityParameters.AuthoritativeEngineID")
+	}
+	pdus, err := x.notificationPDUs(trap)
+	if err != nil {
+		return err
+	}
+	_, err = x.send(pdus, x.mkSnmpPacket(SNMPv2Trap, 0, 0))
+	return err
+}
+
+// SendInform sends an SNMP InformRequest, and waits for the acknowledging
+// Response like any other request. Informs require SNMPv2c or SNMPv3; for
+// SNMPv3 the receiver is the authoritative engine, so its engine ID is
+// discovered as for requests.
+func (x *GoSNMP) SendInform(trap SnmpTrap) (result *SnmpPacket, err error) {
+	return x.SendInformContext(context.Background(), trap)
+}
+
+// SendInformContext is SendInform, stopping with ctx.Err() once ctx is done
+func (x *GoSNMP) SendInformContext(ctx context.Context, trap SnmpTrap) (result *SnmpPacket, err error) {
+	if x.Version == Version1 {
+		return nil, fmt.Errorf("informs require SNMPv2c or SNMPv3")
+	}
+	pdus, err := x.notificationPDUs(trap)
+	if err != nil {
+		return nil, err
+	}
+	return x.sendContext(ctx, pdus, x.mkSnmpPacket(InformRequest, 0, 0))
+}
+
+// notificationPDUs prepends the sysUpTime.0 and snmpTrapOID.0 varbinds of an
+// SNMPv2 notification - RFC 3416 4.2.6
+func (x *GoSNMP) notificationPDUs(trap SnmpTrap) ([]SnmpPDU, error) {
+	trapOID := trap.TrapOID
+	if trapOID == "" {
+		switch {
+		case trap.Enterprise == "":
+			return nil, fmt.Errorf("SNMPv2 notifications require a TrapOID")
+		case trap.GenericTrap >= 0 && trap.GenericTrap < 6:
+			trapOID = fmt.Sprintf("%s.%d", snmpTraps, trap.GenericTrap+1)
+		default:
+			trapOID = fmt.Sprintf("%s.0.%d", strings.TrimSuffix(trap.Enterprise, "."), trap.SpecificTrap)
+		}
+	}
+	if err := x.checkOidCount(len(trap.Variables) + 2); err != nil {
+		return nil, err
+	}
+	pdus := []SnmpPDU{
+		{Name: sysUpTime, Type: TimeTicks, Value: trap.Timestamp},
+		{Name: snmpTrapOID, Type: ObjectIdentifier, Value: trapOID},
+	}
+	return append(pdus, trap.Variables...), nil
+}
+
+// localIPv4 returns the local IPv4 address of Conn, or 0.0.0.0
+func (x *GoSNMP) localIPv4() string {
+	if x.Conn == nil {
+		return "0.0.0.0"
+	}
+	var ip net.IP
+	switch addr := x.Conn.LocalAddr().(type) {
+	case *net.UDPAddr:
+		ip = addr.IP
+	case *net.TCPAddr:
+		ip = addr.IP
+	}
+	if ip.To4() == nil {
+		return "0.0.0.0"
+	}
+	return ip.String()
+}
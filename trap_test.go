@@ -0,0 +1,389 @@
+// Copyright 2012-2014 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// startTrapListener runs a TrapListener on a loopback port, passing each
+// notification to the returned channel
+func startTrapListener(t *testing.T, params *GoSNMP) (*TrapListener, *net.UDPConn, chan *SnmpPacket) {
+	received := make(chan *SnmpPacket, 1)
+	tl := NewTrapListener()
+	tl.Params = params
+	tl.OnNewTrap = func(s *SnmpPacket, u *net.UDPAddr) {
+		received <- s
+	}
+	errch := make(chan error, 1)
+	go func() {
+		errch <- tl.Listen("127.0.0.1:0")
+	}()
+	select {
+	case <-tl.Listening():
+	case err := <-errch:
+		t.Fatalf("Listen() err returned: %v", err)
+	}
+
+	conn, err := net.DialUDP("udp", nil, tl.conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("unable to dial listener: %v", err)
+	}
+	return tl, conn, received
+}
+
+func waitTrap(t *testing.T, received chan *SnmpPacket) *SnmpPacket {
+	select {
+	case s := <-received:
+		return s
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for trap")
+	}
+	return nil
+}
+
+// v1TrapBytes builds an SNMPv1 Trap-PDU by hand
+func v1TrapBytes(t *testing.T) []byte {
+	tlv := func(tag byte, value []byte) []byte {
+		b, err := marshalTLV(tag, value)
+		if err != nil {
+			t.Fatalf("marshalTLV() err returned: %v", err)
+		}
+		return b
+	}
+	enterprise, _ := marshalOID(".1.3.6.1.4.1.8072.3.2.10")
+	vbOid, _ := marshalOID(".1.3.6.1.2.1.2.2.1.1.3")
+	vb := tlv(byte(Sequence), append(tlv(byte(ObjectIdentifier), vbOid), tlv(byte(Integer), []byte{3})...))
+
+	var pdu []byte
+	pdu = append(pdu, tlv(byte(ObjectIdentifier), enterprise)...)
+	pdu = append(pdu, tlv(byte(IPAddress), []byte{192, 0, 2, 7})...)
+	pdu = append(pdu, tlv(byte(Integer), []byte{2})...) // linkDown
+	pdu = append(pdu, tlv(byte(Integer), []byte{0})...)
+	pdu = append(pdu, tlv(byte(TimeTicks), []byte{0x01, 0x00, 0x00})...)
+	pdu = append(pdu, tlv(byte(Sequence), vb)...)
+
+	var msg []byte
+	msg = append(msg, tlv(byte(Integer), []byte{byte(Version1)})...)
+	msg = append(msg, tlv(byte(OctetString), []byte("public"))...)
+	msg = append(msg, tlv(byte(Trap), pdu)...)
+	return tlv(byte(Sequence), msg)
+}
+
+func TestTrapListenerV1(t *testing.T) {
+	tl, conn, received := startTrapListener(t, &GoSNMP{})
+	defer tl.Close()
+	defer conn.Close()
+
+	if _, err := conn.Write(v1TrapBytes(t)); err != nil {
+		t.Fatalf("unable to send trap: %v", err)
+	}
+	s := waitTrap(t, received)
+	if s.Version != Version1 || s.PDUType != Trap || s.Community != "public" {
+		t.Errorf("got version %s PDU %#x community %s", s.Version, s.PDUType, s.Community)
+	}
+	if s.Enterprise != ".1.3.6.1.4.1.8072.3.2.10" || s.AgentAddress != "192.0.2.7" ||
+		s.GenericTrap != 2 || s.SpecificTrap != 0 || s.Timestamp != 65536 {
+		t.Errorf("got enterprise %s agent %s generic %d specific %d timestamp %d",
+			s.Enterprise, s.AgentAddress, s.GenericTrap, s.SpecificTrap, s.Timestamp)
+	}
+	if len(s.Variables) != 1 || s.Variables[0].Name != ".1.3.6.1.2.1.2.2.1.1.3" ||
+		s.Variables[0].Value != 3 {
+		t.Errorf("got variables %v", s.Variables)
+	}
+}
+
+func TestTrapListenerV2cTrapAndInform(t *testing.T) {
+	tl, conn, received := startTrapListener(t, &GoSNMP{})
+	defer tl.Close()
+	defer conn.Close()
+
+	pdus := []SnmpPDU{
+		{Name: ".1.3.6.1.2.1.1.3.0", Type: TimeTicks, Value: 1234},
+		{Name: ".1.3.6.1.6.3.1.1.4.1.0", Type: ObjectIdentifier, Value: ".1.3.6.1.6.3.1.1.5.4"},
+	}
+	for _, pduType := range []PDUType{SNMPv2Trap, InformRequest} {
+		packet := &SnmpPacket{Version: Version2c, Community: "public", PDUType: pduType}
+		msg, err := packet.marshalMsg(pdus, pduType, 42)
+		if err != nil {
+			t.Fatalf("%#x: marshalMsg() err returned: %v", pduType, err)
+		}
+		if _, err = conn.Write(msg); err != nil {
+			t.Fatalf("%#x: unable to send: %v", pduType, err)
+		}
+
+		s := waitTrap(t, received)
+		if s.PDUType != pduType || s.RequestID != 42 || len(s.Variables) != 2 ||
+			s.Variables[1].Value != ".1.3.6.1.6.3.1.1.5.4" {
+			t.Errorf("%#x: got PDU %#x request ID %d variables %v",
+				pduType, s.PDUType, s.RequestID, s.Variables)
+		}
+	}
+
+	// the inform is acknowledged
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, rxBufSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("no response to inform: %v", err)
+	}
+	response, err := (&GoSNMP{}).unmarshalResponse(buf[:n])
+	if err != nil {
+		t.Fatalf("unable to decode inform response: %v", err)
+	}
+	if response.PDUType != GetResponse || response.RequestID != 42 || len(response.Variables) != 2 {
+		t.Errorf("inform response got PDU %#x request ID %d variables %v",
+			response.PDUType, response.RequestID, response.Variables)
+	}
+}
+
+func TestTrapListenerV3Inform(t *testing.T) {
+	usm := testUsm(SHA)
+	params := &GoSNMP{Version: Version3, MsgFlags: AuthNoPriv, SecurityParameters: usm}
+	tl, conn, received := startTrapListener(t, params)
+	defer tl.Close()
+	defer conn.Close()
+
+	// the sender's own security parameters, with the same user
+	sender := testUsm(SHA)
+	sender.AuthoritativeEngineID = "\x80\x00\x1f\x88\x04sender"
+	packet := &SnmpPacket{
+		Version:            Version3,
+		MsgFlags:           AuthNoPriv,
+		SecurityModel:      UserSecurityModel,
+		SecurityParameters: sender,
+		PDUType:            InformRequest,
+		MsgID:              77,
+	}
+	msg, err := packet.marshalMsg([]SnmpPDU{{Name: ".1.3.6.1.2.1.1.3.0", Type: TimeTicks, Value: 99}}, InformRequest, 43)
+	if err != nil {
+		t.Fatalf("marshalMsg() err returned: %v", err)
+	}
+	if _, err = conn.Write(msg); err != nil {
+		t.Fatalf("unable to send: %v", err)
+	}
+	s := waitTrap(t, received)
+	if s.PDUType != InformRequest || s.SecurityParameters.UserName != usm.UserName {
+		t.Errorf("got PDU %#x user %s", s.PDUType, s.SecurityParameters.UserName)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, rxBufSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("no response to inform: %v", err)
+	}
+	manager := &GoSNMP{Version: Version3, MsgFlags: AuthNoPriv, SecurityParameters: testUsm(SHA)}
+	response, err := manager.unmarshalResponse(buf[:n])
+	if err != nil {
+		t.Fatalf("unable to authenticate inform response: %v", err)
+	}
+	if response.PDUType != GetResponse || response.MsgID != 77 || response.RequestID != 43 ||
+		response.SecurityParameters.AuthoritativeEngineID != sender.AuthoritativeEngineID {
+		t.Errorf("inform response got PDU %#x msg ID %d request ID %d engine ID %x",
+			response.PDUType, response.MsgID, response.RequestID, response.SecurityParameters.AuthoritativeEngineID)
+	}
+}
+
+func TestTrapListenerV3StaleInform(t *testing.T) {
+	params := &GoSNMP{Version: Version3, MsgFlags: AuthNoPriv, SecurityParameters: testUsm(SHA)}
+	tl, conn, received := startTrapListener(t, params)
+	defer tl.Close()
+	defer conn.Close()
+
+	// informs from one sender, at engine boots 7 and the given engine time
+	sender := testUsm(SHA)
+	sender.AuthoritativeEngineID = "\x80\x00\x1f\x88\x04sender"
+	send := func(engineTime uint32, requestID uint32) {
+		sender.AuthoritativeEngineTime = engineTime
+		packet := &SnmpPacket{
+			Version:            Version3,
+			MsgFlags:           AuthNoPriv,
+			SecurityModel:      UserSecurityModel,
+			SecurityParameters: sender,
+			PDUType:            InformRequest,
+			MsgID:              requestID,
+		}
+		msg, err := packet.marshalMsg([]SnmpPDU{{Name: ".1.3.6.1.2.1.1.3.0", Type: TimeTicks, Value: 99}}, InformRequest, requestID)
+		if err != nil {
+			t.Fatalf("marshalMsg() err returned: %v", err)
+		}
+		if _, err = conn.Write(msg); err != nil {
+			t.Fatalf("unable to send: %v", err)
+		}
+	}
+	buf := make([]byte, rxBufSize)
+
+	send(1000, 1)
+	if s := waitTrap(t, received); s.RequestID != 1 {
+		t.Fatalf("got request ID %d, expected 1", s.RequestID)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(buf); err != nil {
+		t.Fatalf("no response to inform: %v", err)
+	}
+
+	// a replay of an inform sent more than 150 seconds earlier
+	send(1000-151, 2)
+	select {
+	case s := <-received:
+		t.Errorf("stale inform passed to OnNewTrap: request ID %d", s.RequestID)
+	case <-time.After(200 * time.Millisecond):
+	}
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := conn.Read(buf); err == nil {
+		t.Errorf("stale inform was acknowledged")
+	}
+
+	// within the time window
+	send(1000-149, 3)
+	if s := waitTrap(t, received); s.RequestID != 3 {
+		t.Errorf("got request ID %d, expected 3", s.RequestID)
+	}
+}
+
+func TestTrapListenerClose(t *testing.T) {
+	tl := NewTrapListener()
+	tl.OnNewTrap = func(s *SnmpPacket, u *net.UDPAddr) {}
+	errch := make(chan error, 1)
+	go func() {
+		errch <- tl.Listen("127.0.0.1:0")
+	}()
+	<-tl.Listening()
+	tl.Close()
+
+	select {
+	case err := <-errch:
+		if err != nil {
+			t.Errorf("Listen() after Close() err returned: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("Listen() didn't return after Close()")
+	}
+}
+
+// trapSender returns a GoSNMP connected to the TrapListener's port
+func trapSender(t *testing.T, tl *TrapListener, version SnmpVersion) *GoSNMP {
+	x := &GoSNMP{
+		Target:    "127.0.0.1",
+		Port:      uint16(tl.conn.LocalAddr().(*net.UDPAddr).Port),
+		Community: "public",
+		Version:   version,
+		Timeout:   time.Duration(2) * time.Second,
+		Retries:   1,
+	}
+	if version == Version3 {
+		x.MsgFlags = AuthPriv
+		x.SecurityParameters = testPrivUsm(SHA, AES)
+	}
+	if err := x.Connect(); err != nil {
+		t.Fatalf("Connect() err returned: %v", err)
+	}
+	return x
+}
+
+func TestSendTrapV1(t *testing.T) {
+	tl, conn, received := startTrapListener(t, &GoSNMP{})
+	defer tl.Close()
+	conn.Close()
+
+	x := trapSender(t, tl, Version1)
+	defer x.Conn.Close()
+	err := x.SendTrap(SnmpTrap{
+		Enterprise:   ".1.3.6.1.4.1.8072.3.2.10",
+		GenericTrap:  6,
+		SpecificTrap: 17,
+		Timestamp:    300,
+		Variables:    []SnmpPDU{{Name: ".1.3.6.1.4.1.8072.2.3.2.1", Type: Integer, Value: 60}},
+	})
+	if err != nil {
+		t.Fatalf("SendTrap() err returned: %v", err)
+	}
+	s := waitTrap(t, received)
+	if s.PDUType != Trap || s.Enterprise != ".1.3.6.1.4.1.8072.3.2.10" ||
+		s.AgentAddress != "127.0.0.1" || s.GenericTrap != 6 || s.SpecificTrap != 17 ||
+		s.Timestamp != 300 || len(s.Variables) != 1 || s.Variables[0].Value != 60 {
+		t.Errorf("got PDU %#x enterprise %s agent %s generic %d specific %d timestamp %d variables %v",
+			s.PDUType, s.Enterprise, s.AgentAddress, s.GenericTrap, s.SpecificTrap, s.Timestamp, s.Variables)
+	}
+
+	if err = x.SendTrap(SnmpTrap{}); err == nil {
+		t.Errorf("SendTrap() without an Enterprise expected an error")
+	}
+	if _, err = x.SendInform(SnmpTrap{TrapOID: snmpTraps + ".1"}); err == nil {
+		t.Errorf("SendInform() over SNMPv1 expected an error")
+	}
+}
+
+var testsNotificationTrapOID = []struct {
+	trap     SnmpTrap
+	expected string
+}{
+	{SnmpTrap{TrapOID: ".1.3.6.1.6.3.1.1.5.4"}, ".1.3.6.1.6.3.1.1.5.4"},
+	{SnmpTrap{Enterprise: ".1.3.6.1.4.1.9", GenericTrap: 2}, ".1.3.6.1.6.3.1.1.5.3"},
+	{SnmpTrap{Enterprise: ".1.3.6.1.4.1.9", GenericTrap: 6, SpecificTrap: 5}, ".1.3.6.1.4.1.9.0.5"},
+}
+
+func TestSendTrapV2c(t *testing.T) {
+	tl, conn, received := startTrapListener(t, &GoSNMP{})
+	defer tl.Close()
+	conn.Close()
+
+	x := trapSender(t, tl, Version2c)
+	defer x.Conn.Close()
+	for i, test := range testsNotificationTrapOID {
+		test.trap.Timestamp = 1000
+		test.trap.Variables = []SnmpPDU{{Name: ".1.3.6.1.2.1.2.2.1.1.2", Type: Integer, Value: 2}}
+		if err := x.SendTrap(test.trap); err != nil {
+			t.Fatalf("%d: SendTrap() err returned: %v", i, err)
+		}
+		s := waitTrap(t, received)
+		if s.PDUType != SNMPv2Trap || len(s.Variables) != 3 ||
+			s.Variables[0].Name != sysUpTime || s.Variables[0].Value != 1000 ||
+			s.Variables[1].Name != snmpTrapOID || s.Variables[1].Value != test.expected ||
+			s.Variables[2].Value != 2 {
+			t.Errorf("%d: got PDU %#x variables %v, expected trap OID %s",
+				i, s.PDUType, s.Variables, test.expected)
+		}
+	}
+
+	if err := x.SendTrap(SnmpTrap{}); err == nil {
+		t.Errorf("SendTrap() without a TrapOID expected an error")
+	}
+}
+
+func TestSendInform(t *testing.T) {
+	for _, version := range []SnmpVersion{Version2c, Version3} {
+		params := &GoSNMP{Version: version, MsgFlags: AuthPriv, SecurityParameters: testPrivUsm(SHA, AES)}
+		tl, conn, received := startTrapListener(t, params)
+		conn.Close()
+
+		x := trapSender(t, tl, version)
+		result, err := x.SendInform(SnmpTrap{TrapOID: snmpTraps + ".1", Timestamp: 5})
+		if err != nil {
+			t.Fatalf("%s: SendInform() err returned: %v", version, err)
+		}
+		s := waitTrap(t, received)
+		if s.PDUType != InformRequest || s.Variables[1].Value != snmpTraps+".1" {
+			t.Errorf("%s: got PDU %#x variables %v", version, s.PDUType, s.Variables)
+		}
+		if result.PDUType != GetResponse || len(result.Variables) != 2 {
+			t.Errorf("%s: SendInform() got PDU %#x variables %v", version, result.PDUType, result.Variables)
+		}
+
+		if version == Version3 {
+			if err = x.SendTrap(SnmpTrap{TrapOID: snmpTraps + ".2"}); err != nil {
+				t.Fatalf("%s: SendTrap() err returned: %v", version, err)
+			}
+			if s = waitTrap(t, received); s.PDUType != SNMPv2Trap || s.MsgFlags != AuthPriv {
+				t.Errorf("%s: got PDU %#x flags %d", version, s.PDUType, s.MsgFlags)
+			}
+		}
+		x.Conn.Close()
+		tl.Close()
+	}
+}
@@ -0,0 +1,374 @@
+// Copyright 2012-2014 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+)
+
+//
+// SNMPv3 message processing - RFC 3412. See v3_usm.go for the User-based
+// Security Model.
+//
+
+// SnmpV3MsgFlags contains the security level and reportable flag of an
+// SNMPv3 message
+type SnmpV3MsgFlags uint8
+
+// Possible values of SnmpV3MsgFlags
+const (
+	NoAuthNoPriv SnmpV3MsgFlags = 0x0 // No authentication, and no privacy
+	AuthNoPriv   SnmpV3MsgFlags = 0x1 // Authentication and no privacy
+	AuthPriv     SnmpV3MsgFlags = 0x3 // Authentication and privacy
+	Reportable   SnmpV3MsgFlags = 0x4 // Report PDU must be sent
+)
+
+// SnmpV3SecurityModel describes the security model used by a SnmpV3 connection
+type SnmpV3SecurityModel uint8
+
+// UserSecurityModel is the only SnmpV3SecurityModel currently implemented
+const (
+	UserSecurityModel SnmpV3SecurityModel = 3
+)
+
+// usmStats counters returned in Report PDUs - RFC 3414 5
+const (
+	usmStatsNotInTimeWindows = ".1.3.6.1.6.3.15.1.1.2.0"
+	usmStatsUnknownEngineIDs = ".1.3.6.1.6.3.15.1.1.4.0"
+)
+
+var usmStatsErrors = map[string]string{
+	".1.3.6.1.6.3.15.1.1.1.0": "unsupported security level",
+	".1.3.6.1.6.3.15.1.1.2.0": "not in time window",
+	".1.3.6.1.6.3.15.1.1.3.0": "unknown user name",
+	".1.3.6.1.6.3.15.1.1.4.0": "unknown engine id",
+	".1.3.6.1.6.3.15.1.1.5.0": "wrong digest",
+	".1.3.6.1.6.3.15.1.1.6.0": "decryption error",
+}
+
+// usmStatsCauses are the errors wrapped by reportError for the counters of
+// requests failing authentication or decryption
+var usmStatsCauses = map[string]error{
+	".1.3.6.1.6.3.15.1.1.1.0": ErrAuthentication,
+	".1.3.6.1.6.3.15.1.1.3.0": ErrAuthentication,
+	".1.3.6.1.6.3.15.1.1.5.0": ErrAuthentication,
+	".1.3.6.1.6.3.15.1.1.6.0": ErrDecryption,
+}
+
+// reportError describes the counter carried by a Report PDU
+func reportError(report *SnmpPacket) error {
+	if len(report.Variables) < 1 {
+		return fmt.Errorf("Received an empty report")
+	}
+	oid := report.Variables[0].Name
+	if cause, ok := usmStatsCauses[oid]; ok {
+		return fmt.Errorf("%w: received a report from the agent - %s (%s)", cause, usmStatsErrors[oid], oid)
+	}
+	if msg, ok := usmStatsErrors[oid]; ok {
+		return fmt.Errorf("Received a report from the agent - %s (%s)", msg, oid)
+	}
+	return fmt.Errorf("Received a report from the agent - %s", oid)
+}
+
+// marshal an SNMPv3 message. The scopedPDU is encrypted first (if required
+// by MsgFlags), and the message is authenticated once it's been fully built.
+func (packet *SnmpPacket) marshalV3(pdus []SnmpPDU, requestID uint32) ([]byte, error) {
+	sp := packet.SecurityParameters
+	if sp == nil {
+		return nil, fmt.Errorf("SNMPv3 requires SecurityParameters")
+	}
+	flags := packet.MsgFlags
+	if err := sp.validate(flags); err != nil {
+		return nil, err
+	}
+	switch packet.PDUType {
+	case GetRequest, GetNextRequest, GetBulkRequest, SetRequest, InformRequest:
+		flags |= Reportable
+	}
+
+	// scopedPDU, or encryptedPDU
+	scopedPDU, err := packet.marshalScopedPDU(pdus, requestID)
+	if err != nil {
+		return nil, err
+	}
+	var privParams []byte
+	if flags&AuthPriv == AuthPriv {
+		var encryptedPDU []byte
+		if encryptedPDU, privParams, err = sp.encryptPDU(scopedPDU); err != nil {
+			return nil, err
+		}
+		if scopedPDU, err = marshalTLV(byte(OctetString), encryptedPDU); err != nil {
+			return nil, err
+		}
+	}
+
+	buf := new(bytes.Buffer)
+
+	// version
+	buf.Write([]byte{2, 1, byte(packet.Version)})
+
+	// msgGlobalData
+	var header []byte
+	globalData := new(bytes.Buffer)
+	for _, v := range []uint32{packet.MsgID, rxBufSize} {
+		field, err := marshalTLV(byte(Integer), marshalUint32(v))
+		if err != nil {
+			return nil, err
+		}
+		globalData.Write(field)
+	}
+	globalData.Write([]byte{byte(OctetString), 1, byte(flags)})
+	securityModel := packet.SecurityModel
+	if securityModel == 0 {
+		securityModel = UserSecurityModel
+	}
+	globalData.Write([]byte{byte(Integer), 1, byte(securityModel)})
+	header, err = marshalTLV(byte(Sequence), globalData.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(header)
+
+	// msgSecurityParameters, an octet string wrapping the USM sequence
+	secParams, authOffset, err := sp.marshal(flags, privParams)
+	if err != nil {
+		return nil, err
+	}
+	secParamsTLV, err := marshalTLV(byte(OctetString), secParams)
+	if err != nil {
+		return nil, err
+	}
+	authOffset += buf.Len() + len(secParamsTLV) - len(secParams)
+	buf.Write(secParamsTLV)
+
+	buf.Write(scopedPDU)
+
+	// build up resulting msg - sequence, length then the tail (buf)
+	msg, err := marshalTLV(byte(Sequence), buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	authOffset += len(msg) - buf.Len()
+
+	if flags&AuthNoPriv != 0 {
+		if err = sp.authenticate(msg, authOffset); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// marshal a scopedPDU - the contextEngineID, contextName and PDU
+func (packet *SnmpPacket) marshalScopedPDU(pdus []SnmpPDU, requestID uint32) ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	contextEngineID := packet.ContextEngineID
+	if contextEngineID == "" {
+		contextEngineID = packet.SecurityParameters.AuthoritativeEngineID
+	}
+	for _, v := range []string{contextEngineID, packet.ContextName} {
+		field, err := marshalTLV(byte(OctetString), []byte(v))
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(field)
+	}
+
+	pdu, err := packet.marshalPDU(pdus, requestID)
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(pdu)
+
+	return marshalTLV(byte(Sequence), buf.Bytes())
+}
+
+// unmarshalV3Header parses msgGlobalData and msgSecurityParameters,
+// returning the cursor of the scopedPDU
+func (x *GoSNMP) unmarshalV3Header(packet []byte, cursor int, response *SnmpPacket) (int, error) {
+	if PDUType(packet[cursor]) != Sequence {
+		return 0, fmt.Errorf("Invalid SNMPv3 msgGlobalData header")
+	}
+	_, count := parseLength(packet[cursor:])
+	cursor += count
+
+	rawMsgID, count, err := parseRawField(x.Logger, packet[cursor:], "msgID")
+	if err != nil {
+		return 0, fmt.Errorf("Error parsing SNMPv3 message ID: %s", err.Error())
+	}
+	cursor += count
+	if msgID, ok := rawMsgID.(int); ok {
+		response.MsgID = uint32(msgID)
+		x.logPrintf("Parsed message ID %d", msgID)
+	}
+
+	// msgMaxSize is of no interest to a manager
+	_, count, err = parseRawField(x.Logger, packet[cursor:], "msgMaxSize")
+	if err != nil {
+		return 0, fmt.Errorf("Error parsing SNMPv3 msgMaxSize: %s", err.Error())
+	}
+	cursor += count
+
+	rawMsgFlags, count, err := parseRawField(x.Logger, packet[cursor:], "msgFlags")
+	if err != nil {
+		return 0, fmt.Errorf("Error parsing SNMPv3 msgFlags: %s", err.Error())
+	}
+	cursor += count
+	if msgFlags, ok := rawMsgFlags.(string); ok && len(msgFlags) == 1 {
+		response.MsgFlags = SnmpV3MsgFlags(msgFlags[0])
+		x.logPrintf("Parsed msgFlags %#x", msgFlags[0])
+	}
+
+	rawSecModel, count, err := parseRawField(x.Logger, packet[cursor:], "msgSecurityModel")
+	if err != nil {
+		return 0, fmt.Errorf("Error parsing SNMPv3 msgSecurityModel: %s", err.Error())
+	}
+	cursor += count
+	if secModel, ok := rawSecModel.(int); ok {
+		response.SecurityModel = SnmpV3SecurityModel(secModel)
+	}
+	if response.SecurityModel != UserSecurityModel {
+		return 0, fmt.Errorf("Unsupported SNMPv3 security model %d", response.SecurityModel)
+	}
+
+	// msgSecurityParameters is an octet string containing the USM sequence
+	if Asn1BER(packet[cursor]) != OctetString {
+		return 0, fmt.Errorf("Invalid SNMPv3 msgSecurityParameters header")
+	}
+	secParamsLength, count := parseLength(packet[cursor:])
+	response.SecurityParameters = new(UsmSecurityParameters)
+	if err = response.SecurityParameters.unmarshal(x.Logger, packet, cursor+count); err != nil {
+		return 0, err
+	}
+	cursor += secParamsLength
+
+	return cursor, nil
+}
+
+// unmarshalScopedPDUHeader parses the contextEngineID and contextName of a
+// plaintext scopedPDU, returning the cursor of the PDU
+func (x *GoSNMP) unmarshalScopedPDUHeader(packet []byte, cursor int, response *SnmpPacket) (int, error) {
+	if PDUType(packet[cursor]) != Sequence {
+		return 0, fmt.Errorf("Invalid SNMPv3 scopedPDU header %#x", packet[cursor])
+	}
+	_, count := parseLength(packet[cursor:])
+	cursor += count
+
+	rawContextEngineID, count, err := parseRawField(x.Logger, packet[cursor:], "contextEngineID")
+	if err != nil {
+		return 0, fmt.Errorf("Error parsing SNMPv3 contextEngineID: %s", err.Error())
+	}
+	cursor += count
+	if contextEngineID, ok := rawContextEngineID.(string); ok {
+		response.ContextEngineID = contextEngineID
+	}
+
+	rawContextName, count, err := parseRawField(x.Logger, packet[cursor:], "contextName")
+	if err != nil {
+		return 0, fmt.Errorf("Error parsing SNMPv3 contextName: %s", err.Error())
+	}
+	cursor += count
+	if contextName, ok := rawContextName.(string); ok {
+		response.ContextName = contextName
+	}
+	return cursor, nil
+}
+
+// decryptScopedPDU decrypts the encryptedPDU at cursor, returning the
+// plaintext scopedPDU. Errors wrap ErrDecryption. The caller must hold
+// secMu.
+func (x *GoSNMP) decryptScopedPDU(msg []byte, cursor int, result *SnmpPacket) ([]byte, error) {
+	sp := x.session()
+	if sp == nil || x.MsgFlags&AuthPriv != AuthPriv {
+		return nil, fmt.Errorf("%w: received an encrypted message, but no privacy is configured", ErrDecryption)
+	}
+	if Asn1BER(msg[cursor]) != OctetString {
+		return nil, fmt.Errorf("%w: invalid encryptedPDU header %#x", ErrDecryption, msg[cursor])
+	}
+	length, count := parseLength(msg[cursor:])
+	return sp.decryptPDU(msg[cursor+count:cursor+length], result.SecurityParameters)
+}
+
+// checkSecurity verifies the digest of a received SNMPv3 message against
+// the session's security parameters. The caller must hold secMu.
+func (x *GoSNMP) checkSecurity(msg []byte, result *SnmpPacket) error {
+	if result.MsgFlags&AuthNoPriv == 0 {
+		return nil
+	}
+	sp := x.session()
+	if sp == nil || x.MsgFlags&AuthNoPriv == 0 {
+		return fmt.Errorf("%w: received an authenticated message, but no authentication is configured", ErrAuthentication)
+	}
+	authentic, err := sp.isAuthentic(msg, result.SecurityParameters)
+	if err != nil {
+		return err
+	}
+	if !authentic {
+		return fmt.Errorf("%w: incoming packet is not authentic, discarding", ErrAuthentication)
+	}
+	return nil
+}
+
+// discoverEngine learns the agent's authoritative engine ID, boots and time
+// by sending an unauthenticated request with an empty user - RFC 3414 4.
+// The agent answers with a usmStatsUnknownEngineIDs report.
+func (x *GoSNMP) discoverEngine(ctx context.Context) error {
+	if x.SecurityParameters == nil {
+		return fmt.Errorf("SNMPv3 requires SecurityParameters")
+	}
+	packetOut := &SnmpPacket{
+		Version:            Version3,
+		MsgFlags:           NoAuthNoPriv,
+		SecurityModel:      x.SecurityModel,
+		SecurityParameters: &UsmSecurityParameters{},
+		PDUType:            GetRequest,
+	}
+	result, err := x.sendContext(ctx, []SnmpPDU{}, packetOut)
+	if err != nil {
+		return fmt.Errorf("SNMPv3 engine discovery failed: %s", err.Error())
+	}
+	received := result.SecurityParameters
+	if result.PDUType != Report || received == nil || received.AuthoritativeEngineID == "" {
+		return fmt.Errorf("SNMPv3 engine discovery failed: no engine ID in response")
+	}
+	x.logPrintf("Discovered engine ID %x boots %d time %d", received.AuthoritativeEngineID,
+		received.AuthoritativeEngineBoots, received.AuthoritativeEngineTime)
+	x.secMu.Lock()
+	defer x.secMu.Unlock()
+	sp := x.session()
+	sp.AuthoritativeEngineID = received.AuthoritativeEngineID
+	sp.setEngineTime(received.AuthoritativeEngineBoots, received.AuthoritativeEngineTime)
+	return nil
+}
+
+// session returns the SNMPv3 session: a copy of SecurityParameters, made on
+// first use, that holds the engine ID, boots and time learned from the
+// agent and the keys localized against them. SecurityParameters is only
+// read, so a struct shared by several GoSNMPs isn't changed by any of them;
+// assigning a different one starts a new session. session returns nil
+// without SecurityParameters. The caller must hold secMu.
+func (x *GoSNMP) session() *UsmSecurityParameters {
+	if x.SecurityParameters == nil {
+		return nil
+	}
+	if x.usm == nil || x.usmFrom != x.SecurityParameters {
+		usm := *x.SecurityParameters
+		x.usm, x.usmFrom = &usm, x.SecurityParameters
+	}
+	return x.usm
+}
+
+// engineID returns the authoritative engine ID of the session, "" until
+// it's been discovered
+func (x *GoSNMP) engineID() string {
+	x.secMu.Lock()
+	defer x.secMu.Unlock()
+	if sp := x.session(); sp != nil {
+		return sp.AuthoritativeEngineID
+	}
+	return ""
+}
@@ -0,0 +1,447 @@
+// Copyright 2012-2014 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"encoding/hex"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// Tests in alphabetical order of function being tested
+
+// -- Key localization ---------------------------------------------------------
+
+// RFC 3414 A.3 - password "maplesyrup", engine ID 00...02
+var testsGenLocalizedKey = []struct {
+	authProtocol SnmpV3AuthProtocol
+	localizedKey string
+}{
+	{MD5, "526f5eed9fcce26f8964c2930787d82b"},
+	{SHA, "6695febc9288e36282235fc7151f128497b38f3f"},
+	// RFC 7860 uses the RFC 3414 algorithm with the SHA-2 hashes, but
+	// doesn't publish sample keys; these are the RFC 3414 A.3 inputs,
+	// cross-checked against an independent implementation
+	{SHA224, "0bd8827c6e29f8065e08e09237f177e410f69b90e1782be682075674"},
+	{SHA256, "8982e0e549e866db361a6b625d84cccc11162d453ee8ce3a6445c2d6776f0f8b"},
+	{SHA384, "3b298f16164a11184279d5432bf169e2d2a48307de02b3d3f7e2b4f36eb6f0455a53689a3937eea07319a633d2ccba78"},
+	{SHA512, "22a5a36cedfcc085807a128d7bc6c2382167ad6c0dbc5fdff856740f3d84c099ad1ea87a8db096714d9788bd544047c9021e4229ce27e4c0a69250adfcffbb0b"},
+}
+
+func TestGenLocalizedKey(t *testing.T) {
+	engineID, _ := hex.DecodeString("000000000000000000000002")
+	for i, test := range testsGenLocalizedKey {
+		key, err := genLocalizedKey(test.authProtocol, "maplesyrup", string(engineID))
+		if err != nil {
+			t.Errorf("#%d: %v got err %v", i, test.authProtocol, err)
+			continue
+		}
+		if hex.EncodeToString(key) != test.localizedKey {
+			t.Errorf("#%d: %v got |%x| expected |%s|", i, test.authProtocol, key, test.localizedKey)
+		}
+	}
+}
+
+// Privacy keys, extended as per Blumenthal and Reeder where the localized
+// key is too short. Same password and engine ID as above.
+var testsGenPrivKey = []struct {
+	authProtocol SnmpV3AuthProtocol
+	privProtocol SnmpV3PrivProtocol
+	privKey      string
+}{
+	{MD5, DES, "526f5eed9fcce26f8964c2930787d82b"},
+	{SHA, AES, "6695febc9288e36282235fc7151f1284"},
+	{MD5, AES256, "526f5eed9fcce26f8964c2930787d82bfa24a92467426c2f4b09192be10dfaec"},
+	{SHA, AES192, "6695febc9288e36282235fc7151f128497b38f3f505e07eb"},
+	{MD5, AES256C, "526f5eed9fcce26f8964c2930787d82b79eff44a90650ee0a3a40abfac5acc12"},
+	{SHA, AES256C, "6695febc9288e36282235fc7151f128497b38f3f9b8b6d78936ba6e7d19dfd9c"},
+	{SHA256, AES256, "8982e0e549e866db361a6b625d84cccc11162d453ee8ce3a6445c2d6776f0f8b"},
+}
+
+func TestGenPrivKey(t *testing.T) {
+	engineID, _ := hex.DecodeString("000000000000000000000002")
+	for i, test := range testsGenPrivKey {
+		key, err := genPrivKey(test.authProtocol, test.privProtocol, "maplesyrup", string(engineID))
+		if err != nil {
+			t.Errorf("#%d: %v/%v got err %v", i, test.authProtocol, test.privProtocol, err)
+			continue
+		}
+		if hex.EncodeToString(key) != test.privKey {
+			t.Errorf("#%d: %v/%v got |%x| expected |%s|",
+				i, test.authProtocol, test.privProtocol, key, test.privKey)
+		}
+	}
+}
+
+// -- SNMPv3 messages ----------------------------------------------------------
+
+func testUsm(authProtocol SnmpV3AuthProtocol) *UsmSecurityParameters {
+	return &UsmSecurityParameters{
+		AuthoritativeEngineID:    "\x80\x00\x1f\x88\x80\x5c\x4f\x3e\x3e\x7a\x2a\x59\x52\x00\x00\x00\x00",
+		AuthoritativeEngineBoots: 7,
+		AuthoritativeEngineTime:  1234567,
+		UserName:                 "gosnmp",
+		AuthenticationProtocol:   authProtocol,
+		AuthenticationPassphrase: "authpassword",
+	}
+}
+
+func TestMarshalV3RoundTrip(t *testing.T) {
+	for _, ap := range []SnmpV3AuthProtocol{MD5, SHA, SHA224, SHA256, SHA384, SHA512} {
+		x := &GoSNMP{
+			Version:            Version3,
+			MsgFlags:           AuthNoPriv,
+			SecurityParameters: testUsm(ap),
+		}
+		packetOut := x.mkSnmpPacket(GetResponse, 0, 0)
+		packetOut.MsgID = 0x7fffff01
+		pdus := []SnmpPDU{{Name: ".1.3.6.1.2.1.1.7.0", Type: Integer, Value: 72}}
+
+		msg, err := packetOut.marshalMsg(pdus, GetResponse, 1871507044)
+		if err != nil {
+			t.Fatalf("%v: marshalMsg() err returned: %v", ap, err)
+		}
+
+		result, err := x.unmarshalResponse(msg)
+		if err != nil {
+			t.Fatalf("%v: unmarshalResponse() err returned: %v", ap, err)
+		}
+		if result.MsgID != packetOut.MsgID || result.RequestID != 1871507044 {
+			t.Errorf("%v: got msgID %d requestID %d", ap, result.MsgID, result.RequestID)
+		}
+		if result.MsgFlags != AuthNoPriv {
+			t.Errorf("%v: got msgFlags %#x", ap, result.MsgFlags)
+		}
+		if len(result.SecurityParameters.AuthenticationParameters) != ap.macLength() {
+			t.Errorf("%v: got authentication parameters |%x|", ap,
+				result.SecurityParameters.AuthenticationParameters)
+		}
+		usm := result.SecurityParameters
+		if usm.UserName != "gosnmp" || usm.AuthoritativeEngineBoots != 7 ||
+			usm.AuthoritativeEngineTime != 1234567 ||
+			usm.AuthoritativeEngineID != x.SecurityParameters.AuthoritativeEngineID {
+			t.Errorf("%v: got security parameters %#v", ap, usm)
+		}
+		if result.ContextEngineID != x.SecurityParameters.AuthoritativeEngineID {
+			t.Errorf("%v: got contextEngineID %x", ap, result.ContextEngineID)
+		}
+		if len(result.Variables) != 1 || result.Variables[0].Value != 72 {
+			t.Errorf("%v: got variables %v", ap, result.Variables)
+		}
+
+		// flip a bit in the varbind value, digest must no longer match
+		msg[len(msg)-1] ^= 0x01
+		if _, err = x.unmarshalResponse(msg); err == nil {
+			t.Errorf("%v: expected tampered message to fail authentication", ap)
+		}
+	}
+}
+
+func TestMarshalV3WrongPassphrase(t *testing.T) {
+	agent := &GoSNMP{
+		Version:            Version3,
+		MsgFlags:           AuthNoPriv,
+		SecurityParameters: testUsm(SHA),
+	}
+	packetOut := agent.mkSnmpPacket(GetResponse, 0, 0)
+	msg, err := packetOut.marshalMsg([]SnmpPDU{{Name: ".1.3.6.1.2.1.1.7.0", Type: Integer, Value: 72}}, GetResponse, 1)
+	if err != nil {
+		t.Fatalf("marshalMsg() err returned: %v", err)
+	}
+
+	manager := &GoSNMP{
+		Version:            Version3,
+		MsgFlags:           AuthNoPriv,
+		SecurityParameters: testUsm(SHA),
+	}
+	manager.SecurityParameters.AuthenticationPassphrase = "wrongpassword"
+	if _, err = manager.unmarshalResponse(msg); !errors.Is(err, ErrAuthentication) {
+		t.Errorf("expected wrong passphrase to fail authentication, got err %v", err)
+	}
+}
+
+func TestMarshalV3MissingAuth(t *testing.T) {
+	x := &GoSNMP{
+		Version:            Version3,
+		MsgFlags:           AuthNoPriv,
+		SecurityParameters: &UsmSecurityParameters{UserName: "gosnmp"},
+	}
+	packetOut := x.mkSnmpPacket(GetRequest, 0, 0)
+	if _, err := packetOut.marshalMsg([]SnmpPDU{{Name: ".1.3.6.1.2.1.1.7.0", Type: Null, Value: nil}}, GetRequest, 1); err == nil {
+		t.Errorf("expected error marshalling AuthNoPriv without an authentication protocol")
+	}
+}
+
+func testPrivUsm(authProtocol SnmpV3AuthProtocol, privProtocol SnmpV3PrivProtocol) *UsmSecurityParameters {
+	usm := testUsm(authProtocol)
+	usm.PrivacyProtocol = privProtocol
+	usm.PrivacyPassphrase = "privpassword"
+	return usm
+}
+
+func TestMarshalV3Privacy(t *testing.T) {
+	for _, pp := range []SnmpV3PrivProtocol{DES, AES, AES192, AES256, AES192C, AES256C} {
+		for _, ap := range []SnmpV3AuthProtocol{MD5, SHA, SHA224, SHA512} {
+			x := &GoSNMP{
+				Version:            Version3,
+				MsgFlags:           AuthPriv,
+				SecurityParameters: testPrivUsm(ap, pp),
+			}
+			packetOut := x.mkSnmpPacket(GetResponse, 0, 0)
+			packetOut.MsgID = 42
+			pdus := []SnmpPDU{{Name: ".1.3.6.1.2.1.1.7.0", Type: Integer, Value: 72}}
+
+			msg, err := packetOut.marshalMsg(pdus, GetResponse, 1871507044)
+			if err != nil {
+				t.Fatalf("%v/%v: marshalMsg() err returned: %v", ap, pp, err)
+			}
+			if len(x.SecurityParameters.PrivacyParameters) != 0 {
+				t.Errorf("%v/%v: marshalling changed the session's PrivacyParameters", ap, pp)
+			}
+
+			result, err := x.unmarshalResponse(msg)
+			if err != nil {
+				t.Fatalf("%v/%v: unmarshalResponse() err returned: %v", ap, pp, err)
+			}
+			if len(result.SecurityParameters.PrivacyParameters) != 8 {
+				t.Errorf("%v/%v: got privacy parameters |%x|", ap, pp,
+					result.SecurityParameters.PrivacyParameters)
+			}
+			if result.RequestID != 1871507044 || len(result.Variables) != 1 ||
+				result.Variables[0].Value != 72 {
+				t.Errorf("%v/%v: got requestID %d variables %v", ap, pp,
+					result.RequestID, result.Variables)
+			}
+
+			wrong := &GoSNMP{
+				Version:            Version3,
+				MsgFlags:           AuthPriv,
+				SecurityParameters: testPrivUsm(ap, pp),
+			}
+			wrong.SecurityParameters.PrivacyPassphrase = "wrongpassword"
+			if _, err = wrong.unmarshalResponse(msg); !errors.Is(err, ErrDecryption) {
+				t.Errorf("%v/%v: wrong privacy passphrase, got err %v", ap, pp, err)
+			}
+		}
+	}
+}
+
+func TestMarshalV3PrivacyRequiresAuth(t *testing.T) {
+	x := &GoSNMP{
+		Version:            Version3,
+		MsgFlags:           AuthPriv,
+		SecurityParameters: testUsm(SHA),
+	}
+	packetOut := x.mkSnmpPacket(GetRequest, 0, 0)
+	if _, err := packetOut.marshalMsg([]SnmpPDU{{Name: ".1.3.6.1.2.1.1.7.0", Type: Null, Value: nil}}, GetRequest, 1); err == nil {
+		t.Errorf("expected error marshalling AuthPriv without a privacy protocol")
+	}
+}
+
+// -- Engine discovery and time synchronisation --------------------------------
+
+// v3TestAgent answers discovery with a stale engine boots, then reports the
+// first request as not in the time window
+func v3TestAgent(t *testing.T, conn *net.UDPConn, agent *GoSNMP) {
+	buf := make([]byte, rxBufSize)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		request := new(SnmpPacket)
+		cursor, err := agent.unmarshalHeader(buf[:n], request)
+		if err == nil {
+			request, err = agent.unmarshalPayload(buf[:n], cursor, request)
+		}
+		if err != nil {
+			t.Errorf("agent: unable to decode request: %v", err)
+			return
+		}
+
+		var reply *SnmpPacket
+		var pdus []SnmpPDU
+		switch usm := request.SecurityParameters; {
+		case usm.AuthoritativeEngineID == "":
+			agent.session().AuthoritativeEngineBoots = 7
+			reply = agent.mkSnmpPacket(Report, 0, 0)
+			reply.MsgFlags = NoAuthNoPriv
+			pdus = []SnmpPDU{{Name: usmStatsUnknownEngineIDs, Type: Integer, Value: 1}}
+		case usm.AuthoritativeEngineBoots != 8:
+			agent.session().AuthoritativeEngineBoots = 8
+			reply = agent.mkSnmpPacket(Report, 0, 0)
+			pdus = []SnmpPDU{{Name: usmStatsNotInTimeWindows, Type: Integer, Value: 1}}
+		default:
+			reply = agent.mkSnmpPacket(GetResponse, 0, 0)
+			pdus = []SnmpPDU{{Name: ".1.3.6.1.2.1.1.7.0", Type: Integer, Value: 72}}
+		}
+		reply.MsgID = request.MsgID
+		msg, err := reply.marshalMsg(pdus, reply.PDUType, request.RequestID)
+		if err != nil {
+			t.Errorf("agent: unable to marshal reply: %v", err)
+			return
+		}
+		conn.WriteToUDP(msg, addr)
+	}
+}
+
+func TestSendV3DiscoveryAndTimeWindow(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP() err returned: %v", err)
+	}
+	defer conn.Close()
+	agent := &GoSNMP{
+		Version:            Version3,
+		MsgFlags:           AuthNoPriv,
+		SecurityParameters: testUsm(SHA),
+	}
+	agent.SecurityParameters.AuthoritativeEngineTime = 500
+	go v3TestAgent(t, conn, agent)
+
+	x := &GoSNMP{
+		Target:   "127.0.0.1",
+		Port:     uint16(conn.LocalAddr().(*net.UDPAddr).Port),
+		Version:  Version3,
+		Timeout:  time.Duration(2) * time.Second,
+		Retries:  1,
+		MsgFlags: AuthNoPriv,
+		SecurityParameters: &UsmSecurityParameters{
+			UserName:                 "gosnmp",
+			AuthenticationProtocol:   SHA,
+			AuthenticationPassphrase: "authpassword",
+		},
+	}
+	if err = x.Connect(); err != nil {
+		t.Fatalf("Connect() err returned: %v", err)
+	}
+	defer x.Conn.Close()
+
+	usm := x.usm
+	if usm.AuthoritativeEngineID != agent.SecurityParameters.AuthoritativeEngineID ||
+		usm.AuthoritativeEngineBoots != 7 {
+		t.Fatalf("discovery got engine ID %x boots %d",
+			usm.AuthoritativeEngineID, usm.AuthoritativeEngineBoots)
+	}
+
+	result, err := x.Get([]string{".1.3.6.1.2.1.1.7.0"})
+	if err != nil {
+		t.Fatalf("Get() err returned: %v", err)
+	}
+	if len(result.Variables) != 1 || result.Variables[0].Value != 72 {
+		t.Errorf("Get() got variables %v", result.Variables)
+	}
+	if usm.AuthoritativeEngineBoots != 8 || usm.AuthoritativeEngineTime < 500 {
+		t.Errorf("time window got boots %d time %d",
+			usm.AuthoritativeEngineBoots, usm.AuthoritativeEngineTime)
+	}
+	if sp := x.SecurityParameters; sp.AuthoritativeEngineID != "" || sp.AuthoritativeEngineBoots != 0 {
+		t.Errorf("SecurityParameters got engine ID %x boots %d, expected them unchanged",
+			sp.AuthoritativeEngineID, sp.AuthoritativeEngineBoots)
+	}
+}
+
+func TestSharedSecurityParameters(t *testing.T) {
+	engineIDs := []string{"\x80\x00\x1f\x88\x04gosnmp-agent-1", "\x80\x00\x1f\x88\x04gosnmp-agent-2"}
+	usm := &UsmSecurityParameters{
+		UserName:                 "gosnmp",
+		AuthenticationProtocol:   SHA,
+		AuthenticationPassphrase: "authpassword",
+	}
+
+	for i, engineID := range engineIDs {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatalf("#%d: ListenUDP() err returned: %v", i, err)
+		}
+		defer conn.Close()
+		agent := &GoSNMP{
+			Version:            Version3,
+			MsgFlags:           AuthNoPriv,
+			SecurityParameters: testUsm(SHA),
+		}
+		agent.SecurityParameters.AuthoritativeEngineID = engineID
+		go v3TestAgent(t, conn, agent)
+
+		x := &GoSNMP{
+			Target:             "127.0.0.1",
+			Port:               uint16(conn.LocalAddr().(*net.UDPAddr).Port),
+			Version:            Version3,
+			Timeout:            time.Duration(2) * time.Second,
+			Retries:            1,
+			MsgFlags:           AuthNoPriv,
+			SecurityParameters: usm,
+		}
+		if err = x.Connect(); err != nil {
+			t.Fatalf("#%d: Connect() err returned: %v", i, err)
+		}
+		defer x.Conn.Close()
+		result, err := x.Get([]string{".1.3.6.1.2.1.1.7.0"})
+		if err != nil {
+			t.Fatalf("#%d: Get() err returned: %v", i, err)
+		}
+		if len(result.Variables) != 1 || result.Variables[0].Value != 72 {
+			t.Errorf("#%d: Get() got variables %v", i, result.Variables)
+		}
+		if x.usm.AuthoritativeEngineID != engineID || x.usm.AuthoritativeEngineBoots != 8 {
+			t.Errorf("#%d: got engine ID %q boots %d, expected %q 8", i,
+				x.usm.AuthoritativeEngineID, x.usm.AuthoritativeEngineBoots, engineID)
+		}
+	}
+	if usm.AuthoritativeEngineID != "" || usm.AuthoritativeEngineBoots != 0 ||
+		usm.AuthoritativeEngineTime != 0 || usm.authKey != nil {
+		t.Errorf("the shared SecurityParameters were written: engine ID %q boots %d time %d",
+			usm.AuthoritativeEngineID, usm.AuthoritativeEngineBoots, usm.AuthoritativeEngineTime)
+	}
+}
+
+// -- Reports ------------------------------------------------------------------
+
+// a Report PDU only has a meaning in an SNMPv3 message
+func TestReportFromV2cAgent(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() err returned: %v", err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, rxBufSize)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		request, err := new(GoSNMP).unmarshal(buf[:n])
+		if err != nil {
+			return
+		}
+		report := &SnmpPacket{Version: Version2c, Community: "public", PDUType: Report}
+		msg, err := report.marshalMsg([]SnmpPDU{{Name: ".1.3.6.1.6.3.15.1.1.4.0", Type: Integer, Value: 1}}, Report, request.RequestID)
+		if err != nil {
+			return
+		}
+		conn.WriteTo(msg, addr)
+	}()
+
+	x := &GoSNMP{
+		Target:    "127.0.0.1",
+		Port:      uint16(conn.LocalAddr().(*net.UDPAddr).Port),
+		Community: "public",
+		Version:   Version2c,
+		Timeout:   time.Duration(2) * time.Second,
+	}
+	if err = x.Connect(); err != nil {
+		t.Fatalf("Connect() err returned: %v", err)
+	}
+	defer x.Conn.Close()
+	result, err := x.GetNext([]string{".1.3.6.1.2.1.1.1"})
+	if err != nil {
+		t.Fatalf("GetNext() err returned: %v", err)
+	}
+	if result.PDUType != Report || result.Variables[0].Name != ".1.3.6.1.6.3.15.1.1.4.0" {
+		t.Errorf("GetNext() got %#x %v", result.PDUType, result.Variables)
+	}
+}
@@ -0,0 +1,687 @@
+// Copyright 2012-2014 The GoSNMP Authors. All rights reserved.  Use of this
+// source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package gosnmp
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/md5"
+	crand "crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"sync/atomic"
+	"time"
+)
+
+//
+// User-based Security Model (USM) for SNMPv3 - RFC 3414
+//
+
+// SnmpV3AuthProtocol describes the authentication protocol in use by an
+// authenticated SnmpV3 connection.
+type SnmpV3AuthProtocol uint8
+
+// NoAuth, MD5 and SHA (RFC 3414), and SHA224, SHA256, SHA384 and SHA512
+// (usmHMAC128SHA224AuthProtocol etc, RFC 7860) are implemented
+const (
+	NoAuth SnmpV3AuthProtocol = 1
+	MD5    SnmpV3AuthProtocol = 2
+	SHA    SnmpV3AuthProtocol = 3
+	SHA224 SnmpV3AuthProtocol = 4
+	SHA256 SnmpV3AuthProtocol = 5
+	SHA384 SnmpV3AuthProtocol = 6
+	SHA512 SnmpV3AuthProtocol = 7
+)
+
+// SnmpV3PrivProtocol is the privacy protocol in use by an private SnmpV3
+// connection.
+type SnmpV3PrivProtocol uint8
+
+// NoPriv, DES, AES, AES192, AES256, AES192C and AES256C are implemented.
+// AES192 and AES256 extend the localized key as per Blumenthal
+// (draft-blumenthal-aes-usm-04), AES192C and AES256C as per Reeder
+// (draft-reeder-snmpv3-usm-3desede-00), which is what Cisco devices use.
+const (
+	NoPriv  SnmpV3PrivProtocol = 1
+	DES     SnmpV3PrivProtocol = 2
+	AES     SnmpV3PrivProtocol = 3
+	AES192  SnmpV3PrivProtocol = 4
+	AES256  SnmpV3PrivProtocol = 5
+	AES192C SnmpV3PrivProtocol = 6
+	AES256C SnmpV3PrivProtocol = 7
+)
+
+// ErrDecryption is returned when the scopedPDU of a received message can't
+// be decrypted, typically because of a wrong privacy passphrase or protocol
+var ErrDecryption = errors.New("Unable to decrypt packet")
+
+// UsmSecurityParameters is an implementation of the SNMPv3 User-based
+// Security Model. The authoritative engine fields are those of the remote
+// agent, and the secret keys are localized against AuthoritativeEngineID.
+// A GoSNMP only reads its SecurityParameters, keeping what it learns of the
+// agent in a copy of its own.
+type UsmSecurityParameters struct {
+	AuthoritativeEngineID    string // AuthoritativeEngineID is the snmpEngineID of the agent
+	AuthoritativeEngineBoots uint32 // AuthoritativeEngineBoots is the agent's snmpEngineBoots
+	AuthoritativeEngineTime  uint32 // AuthoritativeEngineTime is the agent's snmpEngineTime
+	UserName                 string // UserName is the USM user (securityName)
+	AuthenticationParameters string // AuthenticationParameters is the HMAC digest of a received message
+	PrivacyParameters        []byte // PrivacyParameters is the salt of a received message
+
+	AuthenticationProtocol   SnmpV3AuthProtocol // AuthenticationProtocol is NoAuth, MD5, SHA or one of the SHA-2 variants
+	AuthenticationPassphrase string             // AuthenticationPassphrase is the user's auth password
+	PrivacyProtocol          SnmpV3PrivProtocol // PrivacyProtocol is NoPriv, DES or one of the AES variants
+	PrivacyPassphrase        string             // PrivacyPassphrase is the user's privacy password
+
+	authKey         []byte // localized authentication key
+	authKeyEngineID string // engine ID authKey was localized against
+	privKey         []byte // localized (and if need be extended) privacy key
+	privKeyEngineID string // engine ID privKey was localized against
+	authOffset      int    // offset of AuthenticationParameters in a received message
+	localDESSalt    uint32 // DES salt counter, started at a random value
+	localAESSalt    uint64 // AES salt counter, started at a random value
+	saltInit        uint32 // set once the salt counters have been seeded
+
+	syncedEngineTime uint32    // AuthoritativeEngineTime as last received
+	syncedAt         time.Time // local time syncedEngineTime was received
+}
+
+// macLength is the length of the truncated HMAC carried in
+// msgAuthenticationParameters
+func (ap SnmpV3AuthProtocol) macLength() int {
+	switch ap {
+	case MD5, SHA:
+		return 12
+	case SHA224:
+		return 16
+	case SHA256:
+		return 24
+	case SHA384:
+		return 32
+	case SHA512:
+		return 48
+	}
+	return 0
+}
+
+// newHash returns the hash function underlying the authentication protocol
+func (ap SnmpV3AuthProtocol) newHash() (func() hash.Hash, error) {
+	switch ap {
+	case MD5:
+		return md5.New, nil
+	case SHA:
+		return sha1.New, nil
+	case SHA224:
+		return sha256.New224, nil
+	case SHA256:
+		return sha256.New, nil
+	case SHA384:
+		return sha512.New384, nil
+	case SHA512:
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("unknown authentication protocol %d", ap)
+}
+
+// String returns the USM name of the authentication protocol
+func (ap SnmpV3AuthProtocol) String() string {
+	switch ap {
+	case NoAuth:
+		return "NoAuth"
+	case MD5:
+		return "MD5"
+	case SHA:
+		return "SHA"
+	case SHA224:
+		return "SHA224"
+	case SHA256:
+		return "SHA256"
+	case SHA384:
+		return "SHA384"
+	case SHA512:
+		return "SHA512"
+	}
+	return fmt.Sprintf("SnmpV3AuthProtocol(%d)", uint8(ap))
+}
+
+// keyLength is the length of the privacy key: the DES key and pre-IV, or
+// the AES key
+func (pp SnmpV3PrivProtocol) keyLength() int {
+	switch pp {
+	case DES, AES:
+		return 16
+	case AES192, AES192C:
+		return 24
+	case AES256, AES256C:
+		return 32
+	}
+	return 0
+}
+
+// String returns the USM name of the privacy protocol
+func (pp SnmpV3PrivProtocol) String() string {
+	switch pp {
+	case NoPriv:
+		return "NoPriv"
+	case DES:
+		return "DES"
+	case AES:
+		return "AES"
+	case AES192:
+		return "AES192"
+	case AES256:
+		return "AES256"
+	case AES192C:
+		return "AES192C"
+	case AES256C:
+		return "AES256C"
+	}
+	return fmt.Sprintf("SnmpV3PrivProtocol(%d)", uint8(pp))
+}
+
+// passwordToKey implements the password to key algorithm of RFC 3414 A.2:
+// the passphrase is repeated to fill 1MB, which is then hashed.
+func passwordToKey(newHash func() hash.Hash, password string) ([]byte, error) {
+	if len(password) == 0 {
+		return nil, fmt.Errorf("zero length passphrase")
+	}
+	h := newHash()
+	var chunk [64]byte
+	pwIndex := 0
+	for count := 0; count < 1048576; count += len(chunk) {
+		for i := range chunk {
+			chunk[i] = password[pwIndex%len(password)]
+			pwIndex++
+		}
+		h.Write(chunk[:])
+	}
+	return h.Sum(nil), nil
+}
+
+// localizeKey localizes key to engineID as per RFC 3414 2.6:
+// Kul = H(Ku || snmpEngineID || Ku)
+func localizeKey(newHash func() hash.Hash, key []byte, engineID string) []byte {
+	h := newHash()
+	h.Write(key)
+	h.Write([]byte(engineID))
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+// genLocalizedKey generates the localized key for password and engineID
+func genLocalizedKey(ap SnmpV3AuthProtocol, password string, engineID string) ([]byte, error) {
+	newHash, err := ap.newHash()
+	if err != nil {
+		return nil, err
+	}
+	key, err := passwordToKey(newHash, password)
+	if err != nil {
+		return nil, err
+	}
+	return localizeKey(newHash, key, engineID), nil
+}
+
+// genPrivKey generates the privacy key for password and engineID. The key
+// is localized using the hash of the authentication protocol, then extended
+// if it's shorter than the privacy protocol needs.
+func genPrivKey(ap SnmpV3AuthProtocol, pp SnmpV3PrivProtocol, password string, engineID string) ([]byte, error) {
+	newHash, err := ap.newHash()
+	if err != nil {
+		return nil, err
+	}
+	key, err := genLocalizedKey(ap, password, engineID)
+	if err != nil {
+		return nil, err
+	}
+	extension := key
+	for len(key) < pp.keyLength() {
+		switch pp {
+		case AES192C, AES256C:
+			// Reeder: Kul || the key localized from Kul used as a passphrase
+			if extension, err = genLocalizedKey(ap, string(extension), engineID); err != nil {
+				return nil, err
+			}
+		default:
+			// Blumenthal: Kul || H(Kul) || H(H(Kul)) ...
+			h := newHash()
+			h.Write(extension)
+			extension = h.Sum(nil)
+		}
+		key = append(key, extension...)
+	}
+	return key[:pp.keyLength()], nil
+}
+
+// localizedAuthKey returns the authentication key localized against
+// engineID. As key generation is expensive the result is cached until the
+// engine ID changes.
+func (sp *UsmSecurityParameters) localizedAuthKey(engineID string) ([]byte, error) {
+	if sp.authKey != nil && sp.authKeyEngineID == engineID {
+		return sp.authKey, nil
+	}
+	key, err := genLocalizedKey(sp.AuthenticationProtocol, sp.AuthenticationPassphrase, engineID)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to localize authentication key: %s", err.Error())
+	}
+	sp.authKey = key
+	sp.authKeyEngineID = engineID
+	return key, nil
+}
+
+// localizedPrivKey returns the privacy key localized against engineID,
+// caching the result like localizedAuthKey
+func (sp *UsmSecurityParameters) localizedPrivKey(engineID string) ([]byte, error) {
+	if sp.privKey != nil && sp.privKeyEngineID == engineID {
+		return sp.privKey, nil
+	}
+	key, err := genPrivKey(sp.AuthenticationProtocol, sp.PrivacyProtocol, sp.PrivacyPassphrase, engineID)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to localize privacy key: %s", err.Error())
+	}
+	sp.privKey = key
+	sp.privKeyEngineID = engineID
+	return key, nil
+}
+
+// validate checks the parameters are sufficient for the security level
+func (sp *UsmSecurityParameters) validate(flags SnmpV3MsgFlags) error {
+	if flags&AuthNoPriv != 0 {
+		if sp.AuthenticationProtocol.macLength() == 0 {
+			return fmt.Errorf("SecurityParameters.AuthenticationProtocol is required for authentication")
+		}
+		if sp.AuthenticationPassphrase == "" {
+			return fmt.Errorf("SecurityParameters.AuthenticationPassphrase is required for authentication")
+		}
+	}
+	if flags&AuthPriv == AuthPriv {
+		if sp.PrivacyProtocol.keyLength() == 0 {
+			return fmt.Errorf("SecurityParameters.PrivacyProtocol is required for privacy")
+		}
+		if sp.PrivacyPassphrase == "" {
+			return fmt.Errorf("SecurityParameters.PrivacyPassphrase is required for privacy")
+		}
+	} else if flags&AuthPriv != 0 && flags&AuthNoPriv == 0 {
+		return fmt.Errorf("Privacy requires authentication")
+	}
+	return nil
+}
+
+// marshal the msgSecurityParameters of an outgoing message. When flags
+// require authentication a zeroed placeholder is written for the digest,
+// and its offset within the returned bytes is returned as authOffset.
+// privParams is the salt used to encrypt the scopedPDU, if any.
+func (sp *UsmSecurityParameters) marshal(flags SnmpV3MsgFlags, privParams []byte) (out []byte, authOffset int, err error) {
+	buf := new(bytes.Buffer)
+
+	// msgAuthoritativeEngineID
+	engineID, err := marshalTLV(byte(OctetString), []byte(sp.AuthoritativeEngineID))
+	if err != nil {
+		return nil, 0, err
+	}
+	buf.Write(engineID)
+
+	// msgAuthoritativeEngineBoots
+	boots, err := marshalTLV(byte(Integer), marshalUint32(sp.AuthoritativeEngineBoots))
+	if err != nil {
+		return nil, 0, err
+	}
+	buf.Write(boots)
+
+	// msgAuthoritativeEngineTime
+	engineTime, err := marshalTLV(byte(Integer), marshalUint32(sp.AuthoritativeEngineTime))
+	if err != nil {
+		return nil, 0, err
+	}
+	buf.Write(engineTime)
+
+	// msgUserName
+	userName, err := marshalTLV(byte(OctetString), []byte(sp.UserName))
+	if err != nil {
+		return nil, 0, err
+	}
+	buf.Write(userName)
+
+	// msgAuthenticationParameters
+	var authParams []byte
+	if flags&AuthNoPriv != 0 {
+		authParams = make([]byte, sp.AuthenticationProtocol.macLength())
+	}
+	authTLV, err := marshalTLV(byte(OctetString), authParams)
+	if err != nil {
+		return nil, 0, err
+	}
+	authOffset = buf.Len() + len(authTLV) - len(authParams)
+	buf.Write(authTLV)
+
+	// msgPrivacyParameters
+	privTLV, err := marshalTLV(byte(OctetString), privParams)
+	if err != nil {
+		return nil, 0, err
+	}
+	buf.Write(privTLV)
+
+	// wrap in a sequence
+	out, err = marshalTLV(byte(Sequence), buf.Bytes())
+	if err != nil {
+		return nil, 0, err
+	}
+	return out, authOffset + len(out) - buf.Len(), nil
+}
+
+// unmarshal the msgSecurityParameters of a received message. cursor is the
+// position of the UsmSecurityParameters sequence within packet, and is used
+// to record where the authentication parameters were found.
+func (sp *UsmSecurityParameters) unmarshal(logger Logger, packet []byte, cursor int) error {
+	if PDUType(packet[cursor]) != Sequence {
+		return fmt.Errorf("Invalid UsmSecurityParameters header")
+	}
+	_, count := parseLength(packet[cursor:])
+	cursor += count
+
+	rawEngineID, count, err := parseRawField(logger, packet[cursor:], "msgAuthoritativeEngineID")
+	if err != nil {
+		return fmt.Errorf("Error parsing SNMPv3 engine ID: %s", err.Error())
+	}
+	cursor += count
+	if engineID, ok := rawEngineID.(string); ok {
+		sp.AuthoritativeEngineID = engineID
+	}
+
+	rawBoots, count, err := parseRawField(logger, packet[cursor:], "msgAuthoritativeEngineBoots")
+	if err != nil {
+		return fmt.Errorf("Error parsing SNMPv3 engine boots: %s", err.Error())
+	}
+	cursor += count
+	if boots, ok := rawBoots.(int); ok {
+		sp.AuthoritativeEngineBoots = uint32(boots)
+	}
+
+	rawTime, count, err := parseRawField(logger, packet[cursor:], "msgAuthoritativeEngineTime")
+	if err != nil {
+		return fmt.Errorf("Error parsing SNMPv3 engine time: %s", err.Error())
+	}
+	cursor += count
+	if engineTime, ok := rawTime.(int); ok {
+		sp.AuthoritativeEngineTime = uint32(engineTime)
+	}
+
+	rawUserName, count, err := parseRawField(logger, packet[cursor:], "msgUserName")
+	if err != nil {
+		return fmt.Errorf("Error parsing SNMPv3 user name: %s", err.Error())
+	}
+	cursor += count
+	if userName, ok := rawUserName.(string); ok {
+		sp.UserName = userName
+	}
+
+	rawAuthParams, count, err := parseRawField(logger, packet[cursor:], "msgAuthenticationParameters")
+	if err != nil {
+		return fmt.Errorf("Error parsing SNMPv3 authentication parameters: %s", err.Error())
+	}
+	if authParams, ok := rawAuthParams.(string); ok {
+		sp.AuthenticationParameters = authParams
+		sp.authOffset = cursor + count - len(authParams)
+	}
+	cursor += count
+
+	rawPrivParams, _, err := parseRawField(logger, packet[cursor:], "msgPrivacyParameters")
+	if err != nil {
+		return fmt.Errorf("Error parsing SNMPv3 privacy parameters: %s", err.Error())
+	}
+	if privParams, ok := rawPrivParams.(string); ok {
+		sp.PrivacyParameters = []byte(privParams)
+	}
+	return nil
+}
+
+// authenticate computes the HMAC of an outgoing message and writes it over
+// the placeholder at authOffset, as per RFC 3414 6.3.1 and 7.3.1.
+func (sp *UsmSecurityParameters) authenticate(msg []byte, authOffset int) error {
+	mac, err := sp.digest(msg, sp.AuthoritativeEngineID)
+	if err != nil {
+		return err
+	}
+	copy(msg[authOffset:], mac)
+	return nil
+}
+
+// isAuthentic verifies the HMAC of a received message. received holds the
+// security parameters unmarshalled from msg.
+func (sp *UsmSecurityParameters) isAuthentic(msg []byte, received *UsmSecurityParameters) (bool, error) {
+	macLength := sp.AuthenticationProtocol.macLength()
+	if len(received.AuthenticationParameters) != macLength {
+		return false, nil
+	}
+
+	// the digest is calculated with the authentication parameters zeroed
+	packet := make([]byte, len(msg))
+	copy(packet, msg)
+	for i := 0; i < macLength; i++ {
+		packet[received.authOffset+i] = 0
+	}
+
+	mac, err := sp.digest(packet, received.AuthoritativeEngineID)
+	if err != nil {
+		return false, err
+	}
+	return hmac.Equal(mac, []byte(received.AuthenticationParameters)), nil
+}
+
+// digest returns the truncated HMAC of msg, keyed with the authentication
+// key localized against engineID
+func (sp *UsmSecurityParameters) digest(msg []byte, engineID string) ([]byte, error) {
+	newHash, err := sp.AuthenticationProtocol.newHash()
+	if err != nil {
+		return nil, err
+	}
+	key, err := sp.localizedAuthKey(engineID)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(newHash, key)
+	mac.Write(msg)
+	return mac.Sum(nil)[:sp.AuthenticationProtocol.macLength()], nil
+}
+
+// initSalt seeds the salt counters with random values, as recommended by
+// RFC 3414 8.1.1.1 and RFC 3826 3.1.2.1
+func (sp *UsmSecurityParameters) initSalt() error {
+	if atomic.LoadUint32(&sp.saltInit) != 0 {
+		return nil
+	}
+	var seed [12]byte
+	if _, err := crand.Read(seed[:]); err != nil {
+		return err
+	}
+	if atomic.CompareAndSwapUint32(&sp.saltInit, 0, 1) {
+		atomic.StoreUint32(&sp.localDESSalt, binary.BigEndian.Uint32(seed[:4]))
+		atomic.StoreUint64(&sp.localAESSalt, binary.BigEndian.Uint64(seed[4:]))
+	}
+	return nil
+}
+
+// encryptPDU encrypts a marshalled scopedPDU, returning the ciphertext and
+// the msgPrivacyParameters (salt) needed to decrypt it
+func (sp *UsmSecurityParameters) encryptPDU(scopedPDU []byte) (ciphertext []byte, privParams []byte, err error) {
+	key, err := sp.localizedPrivKey(sp.AuthoritativeEngineID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = sp.initSalt(); err != nil {
+		return nil, nil, err
+	}
+
+	switch sp.PrivacyProtocol {
+	case DES:
+		// RFC 3414 8.1.1.1: salt is engineBoots || a local counter, and the
+		// IV is the pre-IV (second half of the key) XORed with the salt
+		privParams = make([]byte, 8)
+		binary.BigEndian.PutUint32(privParams, sp.AuthoritativeEngineBoots)
+		binary.BigEndian.PutUint32(privParams[4:], atomic.AddUint32(&sp.localDESSalt, 1))
+		iv := make([]byte, 8)
+		for i := range iv {
+			iv[i] = key[8+i] ^ privParams[i]
+		}
+		block, err := des.NewCipher(key[:8])
+		if err != nil {
+			return nil, nil, err
+		}
+		// pad to a multiple of the block size; the padding is ignored on
+		// decryption as the scopedPDU carries its own length
+		padded := make([]byte, (len(scopedPDU)+des.BlockSize-1)/des.BlockSize*des.BlockSize)
+		copy(padded, scopedPDU)
+		ciphertext = make([]byte, len(padded))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+	default:
+		// RFC 3826 3.1.2.1: salt is a local 64 bit counter, and the IV is
+		// engineBoots || engineTime || salt
+		privParams = make([]byte, 8)
+		binary.BigEndian.PutUint64(privParams, atomic.AddUint64(&sp.localAESSalt, 1))
+		iv := sp.aesIV(sp.AuthoritativeEngineBoots, sp.AuthoritativeEngineTime, privParams)
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		ciphertext = make([]byte, len(scopedPDU))
+		cipher.NewCFBEncrypter(block, iv).XORKeyStream(ciphertext, scopedPDU)
+	}
+	return ciphertext, privParams, nil
+}
+
+// decryptPDU decrypts the encryptedPDU of a received message. received
+// holds the security parameters unmarshalled from the message. The
+// returned error wraps ErrDecryption.
+func (sp *UsmSecurityParameters) decryptPDU(ciphertext []byte, received *UsmSecurityParameters) ([]byte, error) {
+	if len(received.PrivacyParameters) != 8 {
+		return nil, fmt.Errorf("%w: msgPrivacyParameters length %d, expected 8",
+			ErrDecryption, len(received.PrivacyParameters))
+	}
+	key, err := sp.localizedPrivKey(received.AuthoritativeEngineID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDecryption, err.Error())
+	}
+
+	plaintext := make([]byte, len(ciphertext))
+	switch sp.PrivacyProtocol {
+	case DES:
+		if len(ciphertext)%des.BlockSize != 0 {
+			return nil, fmt.Errorf("%w: encryptedPDU length %d is not a multiple of %d",
+				ErrDecryption, len(ciphertext), des.BlockSize)
+		}
+		iv := make([]byte, 8)
+		for i := range iv {
+			iv[i] = key[8+i] ^ received.PrivacyParameters[i]
+		}
+		block, err := des.NewCipher(key[:8])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrDecryption, err.Error())
+		}
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	default:
+		iv := sp.aesIV(received.AuthoritativeEngineBoots, received.AuthoritativeEngineTime,
+			received.PrivacyParameters)
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrDecryption, err.Error())
+		}
+		cipher.NewCFBDecrypter(block, iv).XORKeyStream(plaintext, ciphertext)
+	}
+
+	// a wrong key produces garbage rather than an error, so check the
+	// plaintext looks like a scopedPDU before trimming any padding
+	if len(plaintext) < 2 || PDUType(plaintext[0]) != Sequence {
+		return nil, fmt.Errorf("%w: decrypted scopedPDU has an invalid header", ErrDecryption)
+	}
+	if n := int(plaintext[1] & 0x7f); plaintext[1]&0x80 != 0 && (n > 4 || 2+n > len(plaintext)) {
+		return nil, fmt.Errorf("%w: decrypted scopedPDU has an invalid length", ErrDecryption)
+	}
+	length, _ := parseLength(plaintext)
+	if length > len(plaintext) {
+		return nil, fmt.Errorf("%w: decrypted scopedPDU length %d exceeds %d",
+			ErrDecryption, length, len(plaintext))
+	}
+	return plaintext[:length], nil
+}
+
+// aesIV builds the AES-CFB initialization vector - RFC 3826 3.1.2.1
+func (sp *UsmSecurityParameters) aesIV(boots, engineTime uint32, salt []byte) []byte {
+	iv := make([]byte, 16)
+	binary.BigEndian.PutUint32(iv, boots)
+	binary.BigEndian.PutUint32(iv[4:], engineTime)
+	copy(iv[8:], salt)
+	return iv
+}
+
+// timeWindow is how far, in seconds, the engine time of an authenticated
+// message may be from that expected of its authoritative engine - RFC 3414
+// 3.2 step 7
+const timeWindow = 150
+
+// setEngineTime records the agent's engine boots and time, as learned from
+// a received message
+func (sp *UsmSecurityParameters) setEngineTime(boots, engineTime uint32) {
+	sp.AuthoritativeEngineBoots = boots
+	sp.AuthoritativeEngineTime = engineTime
+	sp.syncedEngineTime = engineTime
+	sp.syncedAt = time.Now()
+}
+
+// refreshEngineTime advances AuthoritativeEngineTime by the time elapsed
+// since it was learned, keeping outgoing messages in the agent's 150 second
+// timeliness window - RFC 3414 2.3
+func (sp *UsmSecurityParameters) refreshEngineTime() {
+	if sp.syncedAt.IsZero() {
+		return
+	}
+	sp.AuthoritativeEngineTime = sp.syncedEngineTime + uint32(time.Since(sp.syncedAt)/time.Second)
+}
+
+// updateEngineTime updates the engine boots and time from an authentic
+// received message if they're more recent - RFC 3414 3.2 step 7b
+func (sp *UsmSecurityParameters) updateEngineTime(received *UsmSecurityParameters) {
+	if received == nil || received.AuthoritativeEngineID != sp.AuthoritativeEngineID {
+		return
+	}
+	if received.AuthoritativeEngineBoots > sp.AuthoritativeEngineBoots ||
+		(received.AuthoritativeEngineBoots == sp.AuthoritativeEngineBoots &&
+			received.AuthoritativeEngineTime > sp.syncedEngineTime) {
+		sp.setEngineTime(received.AuthoritativeEngineBoots, received.AuthoritativeEngineTime)
+	}
+}
+
+// resyncFromReport updates the engine ID, boots and time from a
+// usmStatsUnknownEngineIDs or usmStatsNotInTimeWindows report, returning
+// true if the request should be resent. Time is only taken from
+// authenticated reports, as RFC 3414 3.2 step 7a requires agents to send.
+func (sp *UsmSecurityParameters) resyncFromReport(report *SnmpPacket) bool {
+	received := report.SecurityParameters
+	if received == nil || len(report.Variables) < 1 {
+		return false
+	}
+	switch report.Variables[0].Name {
+	case usmStatsUnknownEngineIDs:
+		if received.AuthoritativeEngineID == "" || received.AuthoritativeEngineID == sp.AuthoritativeEngineID {
+			return false
+		}
+		sp.AuthoritativeEngineID = received.AuthoritativeEngineID
+		sp.setEngineTime(received.AuthoritativeEngineBoots, received.AuthoritativeEngineTime)
+		return true
+	case usmStatsNotInTimeWindows:
+		if report.MsgFlags&AuthNoPriv == 0 || received.AuthoritativeEngineID != sp.AuthoritativeEngineID {
+			return false
+		}
+		sp.setEngineTime(received.AuthoritativeEngineBoots, received.AuthoritativeEngineTime)
+		return true
+	}
+	return false
+}
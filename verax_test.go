@@ -100,10 +100,10 @@ func TestVeraxGet(t *testing.T) {
 				var ok bool
 				if vval, ok = vvalue.(string); !ok {
 					t.Errorf("failed string assert vvalue |%v|", vval)
-				} else if gval, ok = gvalue.(string); !ok {
-					t.Errorf("failed string assert gvalue |%v|", gval)
+				} else if octets, isOctets := gvalue.(OctetStringValue); !isOctets {
+					t.Errorf("failed OctetStringValue assert gvalue |%v|", gvalue)
 
-				} else if strings.HasPrefix(vval, "2010-") {
+				} else if gval = octets.String(); strings.HasPrefix(vval, "2010-") {
 					// skip weird Verax encoded hex strings
 					continue
 				} else if strings.HasPrefix(vval, "2011-") {
@@ -198,10 +198,10 @@ func TestVeraxGetNext(t *testing.T) {
 					var ok bool
 					if vval, ok = vvalue.(string); !ok {
 						t.Errorf("failed string assert vvalue |%v|", vval)
-					} else if gval, ok = gvalue.(string); !ok {
-						t.Errorf("failed string assert gvalue |%v|", gval)
+					} else if octets, isOctets := gvalue.(OctetStringValue); !isOctets {
+						t.Errorf("failed OctetStringValue assert gvalue |%v|", gvalue)
 
-					} else if strings.HasPrefix(vval, "2010-") {
+					} else if gval = octets.String(); strings.HasPrefix(vval, "2010-") {
 						// skip weird Verax encoded hex strings
 						continue
 					} else if strings.HasPrefix(vval, "2011-") {
@@ -302,10 +302,10 @@ func TestVeraxGetBulk(t *testing.T) {
 					var ok bool
 					if vval, ok = vvalue.(string); !ok {
 						t.Errorf("failed string assert vvalue |%v|", vval)
-					} else if gval, ok = gvalue.(string); !ok {
-						t.Errorf("failed string assert gvalue |%v|", gval)
+					} else if octets, isOctets := gvalue.(OctetStringValue); !isOctets {
+						t.Errorf("failed OctetStringValue assert gvalue |%v|", gvalue)
 
-					} else if strings.HasPrefix(vval, "2010-") {
+					} else if gval = octets.String(); strings.HasPrefix(vval, "2010-") {
 						// skip weird Verax encoded hex strings
 						continue
 					} else if strings.HasPrefix(vval, "2011-") {
@@ -645,7 +645,7 @@ LINE:
 
 		case "STRING", "String":
 			oidval = strings.Trim(oidval, `"`)
-			oidval = strings.Replace(oidval, string(0x0d), "", -1)
+			oidval = strings.Replace(oidval, "\r", "", -1)
 			pdu.Type = OctetString
 			pdu.Value = oidval
 
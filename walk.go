@@ -5,11 +5,20 @@
 package gosnmp
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
+	"time"
 )
 
-func (x *GoSNMP) walk(getRequestType PDUType, rootOid string, walkFn WalkFunc) error {
+// maxDatagramSize is the largest UDP payload over IPv4; adaptive
+// max-repetitions only grows while a response would still fit
+const maxDatagramSize = 65507
+
+func (x *GoSNMP) walk(ctx context.Context, getRequestType PDUType, rootOid string, walkFn WalkFunc) error {
 	if rootOid == "" || rootOid == "." {
 		rootOid = baseOid
 	}
@@ -18,19 +27,30 @@ func (x *GoSNMP) walk(getRequestType PDUType, rootOid string, walkFn WalkFunc) e
 		rootOid = string(".") + rootOid
 	}
 
+	root, err := ParseOID(rootOid)
+	if err != nil {
+		return err
+	}
+	if getRequestType == GetBulkRequest && x.Version == Version1 {
+		// GETBULK doesn't exist in SNMPv1
+		getRequestType = GetNextRequest
+	}
 	oid := rootOid
+	last := root // the last oid requested or walked, to check OIDs increase
 	requests := 0
-	maxReps := x.MaxRepetitions
-	if maxReps <= 0 {
-		maxReps = defaultMaxRepetitions
-	}
+	maxReps := x.maxRepetitions()
 
 	getFn := func(oid string) (result *SnmpPacket, err error) {
 		switch getRequestType {
 		case GetBulkRequest:
-			return x.GetBulk([]string{oid}, uint8(x.NonRepeaters), uint8(maxReps))
+			if x.AdaptiveReps {
+				return x.adaptiveBulk(ctx, oid)
+			}
+			// continue with any max-repetitions lowered after tooBig
+			result, maxReps, err = x.getBulk(ctx, []string{oid}, uint32(x.NonRepeaters), maxReps)
+			return result, err
 		case GetNextRequest:
-			return x.GetNext([]string{oid})
+			return x.getBatched(ctx, GetNextRequest, []string{oid})
 		default:
 			return nil, fmt.Errorf("Unsupported request type: %d", getRequestType)
 		}
@@ -39,43 +59,228 @@ func (x *GoSNMP) walk(getRequestType PDUType, rootOid string, walkFn WalkFunc) e
 RequestLoop:
 	for {
 
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		requests++
 		response, err := getFn(oid)
 		if err != nil {
 			return err
 		}
+		if response.Error == NoSuchName && x.Version == Version1 {
+			// SNMPv1 agents report the end of the MIB as noSuchName
+			break RequestLoop
+		}
+		if _, err := checkStatus(response, nil, nullPDUs([]string{oid})); err != nil {
+			return err
+		}
 		if len(response.Variables) == 0 {
 			break RequestLoop
 		}
 
 		for _, v := range response.Variables {
-			if v.Name == oid {
+			if v.Type == EndOfMibView || v.Type == NoSuchObject || v.Type == NoSuchInstance {
+				// agents name the exception after the requested oid
+				x.logPrintf("BulkWalk terminated with type 0x%x", v.Type)
+				break RequestLoop
+			}
+			name, err := v.OID()
+			if err != nil {
+				return err
+			}
+			if name.Compare(last) <= 0 {
 				return fmt.Errorf("OID not increasing: %s", v.Name)
 			}
-			if !strings.HasPrefix(v.Name, rootOid) {
+			if !name.IsDescendantOf(root) {
 				// Not in the requested root range.
 				break RequestLoop
 			}
+			last = name
 			// Report our pdu
 			if err := walkFn(v); err != nil {
 				return err
 			}
-			if v.Type == EndOfMibView || v.Type == NoSuchObject || v.Type == NoSuchInstance {
-				x.Logger.Printf("BulkWalk terminated with type 0x%x", v.Type)
-				break RequestLoop
-			}
 		}
 		// Save last oid for next request
 		oid = response.Variables[len(response.Variables)-1].Name
 	}
-	x.Logger.Printf("BulkWalk completed in %d requests", requests)
+	x.logPrintf("BulkWalk completed in %d requests", requests)
 	return nil
 }
 
-func (x *GoSNMP) walkAll(getRequestType PDUType, rootOid string) (results []SnmpPDU, err error) {
-	err = x.walk(getRequestType, rootOid, func(dataUnit SnmpPDU) error {
+func (x *GoSNMP) walkAll(ctx context.Context, getRequestType PDUType, rootOid string) (results []SnmpPDU, err error) {
+	err = x.walk(ctx, getRequestType, rootOid, func(dataUnit SnmpPDU) error {
 		results = append(results, dataUnit)
 		return nil
 	})
 	return results, err
 }
+
+// walkColumns walks the subtrees below roots side by side, as the columns of
+// a table. Each request, a GETBULK (GETNEXT for SNMPv1) of up to MaxOids
+// columns, continues every unfinished column from its last OID; a column is
+// finished once it leaves its subtree. fn is called with the position in
+// roots of the column of each varbind, in OID order within each column.
+func (x *GoSNMP) walkColumns(ctx context.Context, roots []OID, fn func(column int, oid OID, pdu SnmpPDU) error) error {
+	last := make([]OID, len(roots))
+	copy(last, roots)
+	done := make([]bool, len(roots))
+	limit := x.MaxOids
+	if limit <= 0 {
+		limit = maxOids
+	}
+	maxReps := x.maxRepetitions()
+	requests := 0
+
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// the unfinished columns, up to MaxOids of them
+		var columns []int
+		var oids []string
+		for i := range roots {
+			if !done[i] && len(columns) < limit {
+				columns = append(columns, i)
+				oids = append(oids, last[i].String())
+			}
+		}
+		if len(columns) == 0 {
+			break
+		}
+
+		requests++
+		var response *SnmpPacket
+		var err error
+		if x.Version == Version1 {
+			response, err = x.getBatched(ctx, GetNextRequest, oids)
+		} else {
+			// continue with any max-repetitions lowered after tooBig
+			response, maxReps, err = x.getBulk(ctx, oids, 0, maxReps)
+		}
+		if err != nil {
+			return err
+		}
+		if i := int(response.ErrorIndex); response.Error == NoSuchName && i > 0 && i <= len(columns) {
+			// SNMPv1 agents report the end of the MIB as noSuchName
+			done[columns[i-1]] = true
+			continue
+		}
+		if _, err := checkStatus(response, nil, nullPDUs(oids)); err != nil {
+			return err
+		}
+
+		// varbinds repeat the columns in order, a row at a time
+		progress := false
+		for i, v := range response.Variables {
+			column := columns[i%len(columns)]
+			if done[column] {
+				continue
+			}
+			progress = true
+			if v.Type == EndOfMibView || v.Type == NoSuchObject || v.Type == NoSuchInstance {
+				done[column] = true
+				continue
+			}
+			name, err := v.OID()
+			if err != nil {
+				return err
+			}
+			if name.Compare(last[column]) <= 0 {
+				return fmt.Errorf("OID not increasing: %s", v.Name)
+			}
+			if !name.IsDescendantOf(roots[column]) {
+				done[column] = true
+				continue
+			}
+			last[column] = name
+			if err := fn(column, name, v); err != nil {
+				return err
+			}
+		}
+		if !progress {
+			return fmt.Errorf("No varbinds returned for %d columns", len(columns))
+		}
+	}
+	x.logPrintf("Column walk completed in %d requests", requests)
+	return nil
+}
+
+// maxRepetitions returns MaxRepetitions, or the default
+func (x *GoSNMP) maxRepetitions() uint32 {
+	if x.MaxRepetitions <= 0 {
+		return defaultMaxRepetitions
+	}
+	return uint32(x.MaxRepetitions)
+}
+
+// learnedReps is the max-repetitions learned for a target by adaptiveBulk,
+// and the lowest that failed with tooBig or a timeout, if any
+type learnedReps struct {
+	reps    uint32
+	ceiling uint32
+}
+
+// adaptiveBulk sends the GETBULK of a walk with the max-repetitions learned
+// for the target. Max-repetitions halves after tooBig or a timeout, and grows
+// by half after a response that was full, fast (within a quarter of the
+// timeout for one try) and small enough to grow and still fit in a datagram -
+// though never back to a value that failed.
+func (x *GoSNMP) adaptiveBulk(ctx context.Context, oid string) (*SnmpPacket, error) {
+	target := net.JoinHostPort(x.Target, strconv.Itoa(int(x.Port)))
+	x.mu.Lock()
+	learned, ok := x.reps[target]
+	x.mu.Unlock()
+	if !ok {
+		learned.reps = x.maxRepetitions()
+	}
+
+	for {
+		start := time.Now()
+		result, used, err := x.getBulk(ctx, []string{oid}, uint32(x.NonRepeaters), learned.reps)
+		elapsed := time.Since(start)
+		if used < learned.reps {
+			// tooBig
+			learned.ceiling = used * 2
+		}
+		if errors.Is(err, ErrTimeout) && used > 1 {
+			learned.ceiling = used
+			learned.reps = used / 2
+			x.logPrintf("GetBulk timed out, retrying with max-repetitions %d", learned.reps)
+			x.learnReps(target, learned)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		learned.reps = used
+		tries := x.Retries + 1
+		if tries < 1 {
+			tries = 1
+		}
+		fast := elapsed < x.Timeout/time.Duration(4*tries)
+		full := result.Error == NoError && len(result.Variables) >= int(used)
+		grown := used + used/2 + 1
+		if learned.ceiling > 0 && grown >= learned.ceiling {
+			grown = learned.ceiling - 1
+		}
+		if fast && full && grown > used &&
+			x.headerSize()+estimatedSize(result.Variables)*int(grown)/int(used) <= maxDatagramSize {
+			learned.reps = grown
+			x.logPrintf("GetBulk was fast, growing max-repetitions to %d", learned.reps)
+		}
+		x.learnReps(target, learned)
+		return result, nil
+	}
+}
+
+// learnReps records the max-repetitions learned for target
+func (x *GoSNMP) learnReps(target string, learned learnedReps) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.reps == nil {
+		x.reps = make(map[string]learnedReps)
+	}
+	x.reps[target] = learned
+}